@@ -0,0 +1,444 @@
+// Copyright (c) 2016 - Sarjono Mukti Aji <me@simukti.net>
+// Unless otherwise noted, this source code license is MIT-License
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/simukti/tmd/tumblr"
+)
+
+const (
+	// EXITOK every blog, page and file succeed
+	EXITOK = 0
+
+	// EXITFAILED some blogs, pages or files failed, or archive is not intact
+	EXITFAILED = 1
+
+	// EXITUSAGE invalid command, flag or input list, nothing is done
+	EXITUSAGE = 2
+)
+
+// command single subcommand of tmd, first argument which is not a flag
+type command struct {
+	name    string
+	args    string
+	summary string
+	flags   func(fs *flag.FlagSet)
+	run     func(cmd command, args []string) int
+}
+
+// usageError error which is printed along with command usage
+type usageError struct {
+	error
+}
+
+// flagError flag parsing error, flag package already print it with command usage
+type flagError struct {
+	error
+}
+
+// archivedBlog blog folder read by archive command, err is set if its folder can not be resolved
+type archivedBlog struct {
+	dest string
+	name string
+	err  error
+}
+
+var (
+	commands = []command{
+		{"download", "[flags]", "Download media and posts of blogs, this is the default command", downloadFlags, runDownload},
+		{"sync", "[flags]", "Download only posts newer than last finished run, same as download -since-last", downloadFlags, runDownload},
+		{"verify", "[-d dir] [-u blogs | -s file] [-hash]", "Check every downloaded file against blog manifest", verifyFlags, runVerify},
+		{"ls", "[-d dir] [-u blogs | -s file]", "List archived blogs, or downloaded files of given blogs", archiveFlags, runList},
+		{"stats", "[-d dir] [-u blogs | -s file]", "Show files, size, progress and last sync of archived blogs per media", archiveFlags, runStats},
+	}
+
+	verifyHash bool
+	// unameFlag -u is given on command line, not only by config file or environment
+	unameFlag bool
+)
+
+func main() {
+	os.Exit(run(os.Args[1:]))
+}
+
+// run dispatch subcommand, flags without subcommand are download flags
+func run(args []string) int {
+	if len(args) == 0 {
+		usage()
+		return EXITUSAGE
+	}
+	if len(args) == 1 && (args[0] == "-h" || args[0] == "-help" || args[0] == "--help") {
+		usage()
+		return EXITOK
+	}
+
+	name := "download"
+	if !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+
+	if name == "help" {
+		if len(args) > 0 {
+			if cmd, ok := findCommand(args[0]); ok {
+				cmd.flagSet().Usage()
+				return EXITOK
+			}
+		}
+		usage()
+		return EXITOK
+	}
+
+	cmd, ok := findCommand(name)
+	if !ok {
+		printError("[ERROR] Unknown command %s", name)
+		usage()
+		return EXITUSAGE
+	}
+
+	return cmd.run(cmd, args)
+}
+
+func findCommand(name string) (command, bool) {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd, true
+		}
+	}
+
+	return command{}, false
+}
+
+func usage() {
+	fmt.Println("Usage: tmd <command> [flags]")
+	fmt.Println("\nCommands:")
+	for _, cmd := range commands {
+		fmt.Printf("  %-9s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Println("\nRun tmd help <command> or tmd <command> -h for flags of a command, tmd [flags] is tmd download [flags].")
+	fmt.Printf("Exit status is %d on success, %d if any blog, page or file failed and %d on invalid usage.\n",
+		EXITOK, EXITFAILED, EXITUSAGE)
+}
+
+// flagSet flag set of command with its usage
+func (cmd command) flagSet() *flag.FlagSet {
+	fs := flag.NewFlagSet(cmd.name, flag.ContinueOnError)
+	fs.SetOutput(os.Stdout)
+	fs.Usage = func() {
+		fmt.Printf("Usage: tmd %s %s\n%s\n\nFlags:\n", cmd.name, cmd.args, cmd.summary)
+		fs.PrintDefaults()
+	}
+	cmd.flags(fs)
+
+	return fs
+}
+
+// fail print error of flags, config or input list and return exit status
+func (cmd command) fail(fs *flag.FlagSet, err error) int {
+	if fe, ok := err.(flagError); ok {
+		if fe.error == flag.ErrHelp {
+			return EXITOK
+		}
+		return EXITUSAGE
+	}
+
+	printError("[ERROR] %s", err.Error())
+	if _, ok := err.(usageError); ok {
+		fs.Usage()
+	}
+
+	return EXITUSAGE
+}
+
+func printError(format string, a ...interface{}) {
+	fmt.Println(color.New(color.FgHiRed, color.Bold).SprintfFunc()(format, a...))
+}
+
+// archiveFlags register flags of commands which only read archive folder
+func archiveFlags(fs *flag.FlagSet) {
+	defaultDest, _ := os.Getwd()
+	fs.StringVar(&dest, "d", defaultDest, "Destination directory of archive")
+	fs.StringVar(&uname, "u", "", "Blog name -- comma separated for multiple blog, default is every blog in -d and config file")
+	fs.StringVar(&input, "s", "", "Input file of blog list (see download), per blog dest is used, - is stdin")
+	fs.StringVar(&config, "config", os.Getenv("TMD_CONFIG"), "TOML config file, only dest, blogs and [[blog]] sections are used, default from TMD_CONFIG env")
+}
+
+func verifyFlags(fs *flag.FlagSet) {
+	archiveFlags(fs)
+	fs.BoolVar(&verifyHash, "hash", false, "Also compare sha256 of every file, it read whole archive")
+}
+
+// parseArchive parse flags of archive command into archived blog list, blog folder is resolved
+// like download does: per blog dest of config file or input list and blog name of custom domain
+func parseArchive(fs *flag.FlagSet, args []string) ([]archivedBlog, error) {
+	if err := fs.Parse(args); err != nil {
+		return nil, flagError{err}
+	}
+	// applyConfig set flags too, so it is checked before
+	unameFlag = false
+	fs.Visit(func(f *flag.Flag) {
+		unameFlag = unameFlag || f.Name == "u"
+	})
+	if err := applyConfig(fs, config); err != nil {
+		return nil, err
+	}
+	if fs.NArg() > 0 {
+		return nil, usageError{fmt.Errorf("Unexpected argument %s", fs.Arg(0))}
+	}
+
+	scan := false
+	switch {
+	case uname != "" && uname != ".":
+		for _, u := range splitList(uname) {
+			if err := addBlog(u, nil); err != nil {
+				return nil, err
+			}
+		}
+	case input != "" && input != ".":
+		if err := loadList(input); err != nil {
+			return nil, err
+		}
+	default:
+		scan = true
+		for _, b := range blogOrder {
+			if err := addBlog(b, nil); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	downloader, err := tumblr.New(tumblr.Destination(dest), tumblr.Output(ioutil.Discard))
+	if err != nil {
+		return nil, err
+	}
+
+	blogs := []archivedBlog{}
+	seen := map[string]bool{}
+	for _, b := range list {
+		opts := append([]tumblr.Option{}, blogSections[b]...)
+		opts = append(opts, listOpts[b]...)
+		folder, name, err := downloader.Folder(b, opts...)
+		if err != nil {
+			blogs = append(blogs, archivedBlog{name: b, err: err})
+			continue
+		}
+		seen[filepath.Join(folder, name)] = true
+		// config blog which is not downloaded yet is not an archive
+		if _, err := os.Stat(filepath.Join(folder, name, tumblr.MANIFESTFILE)); scan && err != nil {
+			continue
+		}
+		blogs = append(blogs, archivedBlog{dest: folder, name: name})
+	}
+
+	if !scan {
+		return blogs, nil
+	}
+
+	archived, err := archivedBlogs(dest)
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range archived {
+		if !seen[filepath.Join(a.dest, a.name)] {
+			blogs = append(blogs, a)
+		}
+	}
+
+	return blogs, nil
+}
+
+// manifest read manifest inside blog folder
+func (a archivedBlog) manifest() (*tumblr.Manifest, error) {
+	if a.err != nil {
+		return nil, a.err
+	}
+
+	return tumblr.ReadManifest(filepath.Join(a.dest, a.name, tumblr.MANIFESTFILE))
+}
+
+// archivedBlogs every blog folder inside dir which has manifest
+func archivedBlogs(dir string) ([]archivedBlog, error) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	blogs := []archivedBlog{}
+	for _, fi := range infos {
+		if !fi.IsDir() {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(dir, fi.Name(), tumblr.MANIFESTFILE)); err == nil {
+			blogs = append(blogs, archivedBlog{dest: dir, name: fi.Name()})
+		}
+	}
+
+	return blogs, nil
+}
+
+// runVerify check every downloaded file of archived blogs, exit status is EXITFAILED if any file is broken
+func runVerify(cmd command, args []string) int {
+	fs := cmd.flagSet()
+	blogs, err := parseArchive(fs, args)
+	if err != nil {
+		return cmd.fail(fs, err)
+	}
+
+	status := EXITOK
+	for _, a := range blogs {
+		blog := a.name
+		checked, broken, err := 0, []tumblr.VerifyResult{}, a.err
+		if err == nil {
+			checked, broken, err = tumblr.Verify(a.dest, blog, verifyHash)
+		}
+		if err != nil {
+			printError("[ERROR] [%s] %s", blog, err.Error())
+			status = EXITFAILED
+			continue
+		}
+
+		for _, r := range broken {
+			msg := fmt.Sprintf("\t[%s] [%s] [%s]", strings.ToUpper(r.Problem), r.Entry.PostID, r.Path)
+			if r.Err != nil {
+				msg += " " + r.Err.Error()
+			}
+			printError("%s", msg)
+		}
+
+		if len(broken) > 0 {
+			status = EXITFAILED
+			printError("[BROKEN] [%s] %d of %d files", blog, len(broken), checked)
+		} else {
+			fmt.Println(color.GreenString("[OK] [%s] %d files", blog, checked))
+		}
+	}
+
+	return status
+}
+
+// runList print archived blogs, or files of blogs given by -u
+func runList(cmd command, args []string) int {
+	fs := cmd.flagSet()
+	blogs, err := parseArchive(fs, args)
+	if err != nil {
+		return cmd.fail(fs, err)
+	}
+
+	status := EXITOK
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	// file rows only for blogs given by -u on command line, not by blogs of config file or environment
+	files := unameFlag && uname != "" && uname != "."
+	if files {
+		fmt.Fprintln(w, "BLOG\tMEDIA\tPOST\tSIZE\tSTATUS\tFILE")
+	} else {
+		fmt.Fprintln(w, "BLOG\tFILES\tSIZE")
+	}
+
+	for _, a := range blogs {
+		blog := a.name
+		m, err := a.manifest()
+		if err != nil {
+			w.Flush()
+			printError("[ERROR] [%s] %s", blog, err.Error())
+			status = EXITFAILED
+			continue
+		}
+
+		count, size := 0, int64(0)
+		for _, e := range m.Entries() {
+			if files {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", blog, e.Media, e.PostID, humanSize(e.Size), e.Status, e.Dest)
+			}
+			if e.Status == tumblr.STATUSDONE {
+				count++
+				size += e.Size
+			}
+		}
+		if !files {
+			fmt.Fprintf(w, "%s\t%d\t%s\n", blog, count, humanSize(size))
+		}
+	}
+	w.Flush()
+
+	return status
+}
+
+// runStats print file count, size, page progress and last sync per blog and media
+func runStats(cmd command, args []string) int {
+	fs := cmd.flagSet()
+	blogs, err := parseArchive(fs, args)
+	if err != nil {
+		return cmd.fail(fs, err)
+	}
+
+	status := EXITOK
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "BLOG\tMEDIA\tFILES\tFAILED\tSIZE\tPAGE\tLAST SYNC")
+	for _, a := range blogs {
+		blog := a.name
+		m, err := a.manifest()
+		if err != nil {
+			w.Flush()
+			printError("[ERROR] [%s] %s", blog, err.Error())
+			status = EXITFAILED
+			continue
+		}
+
+		entries := m.Entries()
+		for _, media := range m.Media() {
+			done, failed, size := 0, 0, int64(0)
+			for _, e := range entries {
+				if e.Media != media {
+					continue
+				}
+				if e.Status == tumblr.STATUSDONE {
+					done++
+					size += e.Size
+				} else {
+					failed++
+				}
+			}
+
+			page := "-"
+			if p, ok := m.Progress(media); ok {
+				page = fmt.Sprintf("%d/%d", p.Page, p.Total)
+				if p.Complete {
+					page += " complete"
+				}
+			}
+			lastSync := "-"
+			if s, ok := m.LastSync(media); ok {
+				lastSync = s.Updated.Format(time.RFC3339)
+			}
+
+			fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%s\t%s\t%s\n", blog, media, done, failed, humanSize(size), page, lastSync)
+		}
+	}
+	w.Flush()
+
+	return status
+}
+
+// humanSize size in the largest binary unit which is at least 1
+func humanSize(n int64) string {
+	switch s := float64(n); {
+	case s >= tumblr.GiB:
+		return fmt.Sprintf("%.2f GiB", s/tumblr.GiB)
+	case s >= tumblr.MiB:
+		return fmt.Sprintf("%.2f MiB", s/tumblr.MiB)
+	case s >= tumblr.KiB:
+		return fmt.Sprintf("%.2f KiB", s/tumblr.KiB)
+	}
+
+	return fmt.Sprintf("%d B", n)
+}
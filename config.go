@@ -0,0 +1,165 @@
+// Copyright (c) 2016 - Sarjono Mukti Aji <me@simukti.net>
+// Unless otherwise noted, this source code license is MIT-License
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/simukti/tmd/tumblr"
+)
+
+const (
+	// ENVPREFIX prefix of environment variable of every config key, e.g. TMD_PER_PAGE
+	ENVPREFIX = "TMD_"
+)
+
+var (
+	// configKeys config file key (and environment variable) of every flag
+	configKeys = map[string]string{
+		"blogs": "u", "input": "s", "posts": "p", "post_file": "pf", "dest": "d", "media": "m",
+		"batch": "b", "queue": "q", "connect_timeout": "cto", "download_timeout": "dto",
+		"per_page": "pp", "limit_page": "lp", "after": "after", "before": "before",
+		"tag": "tag", "exclude_tag": "exclude-tag", "originals_only": "originals-only",
+		"reblogs_only": "reblogs-only", "api": "api", "key": "key", "page_retry": "page-retry",
+		"page_backoff": "page-backoff", "media_retry": "media-retry", "media_backoff": "media-backoff",
+		"jitter": "jitter", "meta": "meta", "embed_cmd": "embed-cmd", "layout": "layout",
+		"photo": "photo", "photo_original": "photo-original", "since_last": "since-last",
+		"dry_run": "dry-run", "plan": "plan",
+	}
+
+	// blogSections download options of blogs configured in config file
+	blogSections = map[string][]tumblr.Option{}
+	blogOrder    = []string{}
+)
+
+// configFile per blog sections of config file, other keys are flag values
+type configFile struct {
+	Blog []blogEntry `toml:"blog"`
+}
+
+// applyConfig set every flag of fs which is not given on command line from environment variable,
+// or from config file if environment variable is not set either
+func applyConfig(fs *flag.FlagSet, file string) error {
+	explicit := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+
+	env := map[string]string{}
+	for key, name := range configKeys {
+		if v, ok := os.LookupEnv(ENVPREFIX + strings.ToUpper(key)); ok {
+			env[name] = v
+		}
+	}
+
+	// [[blog]] section is part of config file, so it does not override command line or environment either
+	given := map[string]bool{}
+	for name := range explicit {
+		given[name] = true
+	}
+	for name := range env {
+		given[name] = true
+	}
+
+	values := map[string]string{}
+	if file != "" {
+		fileValues, err := loadConfig(file, given)
+		if err != nil {
+			return err
+		}
+		values = fileValues
+	}
+	for name, v := range env {
+		values[name] = v
+	}
+
+	for name, v := range values {
+		if explicit[name] || fs.Lookup(name) == nil {
+			continue
+		}
+		if err := fs.Set(name, v); err != nil {
+			return fmt.Errorf("Invalid value %s of -%s: %s", v, name, err.Error())
+		}
+	}
+
+	return nil
+}
+
+// loadConfig read toml config file into flag values, [[blog]] sections go into blogSections
+// without fields of given flags
+func loadConfig(file string, given map[string]bool) (map[string]string, error) {
+	raw := map[string]interface{}{}
+	if _, err := toml.DecodeFile(file, &raw); err != nil {
+		return nil, fmt.Errorf("Config file %s: %s", file, err.Error())
+	}
+
+	values := map[string]string{}
+	for key, v := range raw {
+		if key == "blog" {
+			continue
+		}
+		name, ok := configKeys[key]
+		if !ok {
+			known := []string{}
+			for k := range configKeys {
+				known = append(known, k)
+			}
+			sort.Strings(known)
+			return nil, fmt.Errorf("Unknown config key %s, allowed key is: blog,%s", key, strings.Join(known, ","))
+		}
+		values[name] = configValue(v)
+	}
+
+	cf := configFile{}
+	if _, err := toml.DecodeFile(file, &cf); err != nil {
+		return nil, fmt.Errorf("Config file %s: %s", file, err.Error())
+	}
+	for _, e := range cf.Blog {
+		if strings.TrimSpace(e.Name) == "" {
+			return nil, fmt.Errorf("Every [[blog]] section of config file must have name")
+		}
+		// same name as -u, so blog url or custom domain match its section too
+		name, err := tumblr.ParseBlog(e.Name)
+		if err != nil {
+			return nil, err
+		}
+		opts, err := e.options(given)
+		if err != nil {
+			return nil, fmt.Errorf("[%s] %s", name, err.Error())
+		}
+		if _, ok := blogSections[name]; !ok {
+			blogOrder = append(blogOrder, name)
+		}
+		blogSections[name] = opts
+	}
+
+	return values, nil
+}
+
+// configValue flag string of toml value, list is comma separated
+func configValue(v interface{}) string {
+	switch tv := v.(type) {
+	case string:
+		return tv
+	case time.Time:
+		return tv.Format(time.RFC3339)
+	case int64:
+		return strconv.FormatInt(tv, 10)
+	case []interface{}:
+		items := []string{}
+		for _, i := range tv {
+			items = append(items, configValue(i))
+		}
+		return strings.Join(items, ",")
+	}
+
+	return fmt.Sprint(v)
+}
@@ -0,0 +1,164 @@
+// Copyright (c) 2016 - Sarjono Mukti Aji <me@simukti.net>
+// Unless otherwise noted, this source code license is MIT-License
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/simukti/tmd/tumblr"
+)
+
+const testConfig = `
+dest = "ROOT/file"
+per_page = 30
+media = ["photo", "video"]
+jitter = 0.5
+
+[[blog]]
+name = "sectioned"
+dest = "ROOT/section"
+media = ["photo"]
+per_page = 10
+`
+
+// withConfig write config file into temp dir which has file, section, env and flag folders,
+// ROOT in content and env is that dir, [[blog]] sections parsed by previous test are reset
+func withConfig(t *testing.T, content string, env map[string]string) (string, func()) {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "tmd-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, sub := range []string{"file", "section", "env", "flag"} {
+		if err := os.Mkdir(filepath.Join(dir, sub), 0700); err != nil {
+			t.Fatal(err)
+		}
+	}
+	file := filepath.Join(dir, "tmd.toml")
+	if err := ioutil.WriteFile(file, []byte(strings.Replace(content, "ROOT", dir, -1)), 0600); err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range env {
+		os.Setenv(k, strings.Replace(v, "ROOT", dir, -1))
+	}
+	blogSections = map[string][]tumblr.Option{}
+	blogOrder = []string{}
+
+	return file, func() {
+		for k := range env {
+			os.Unsetenv(k)
+		}
+		os.RemoveAll(dir)
+	}
+}
+
+func TestApplyConfigPrecedence(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		env     map[string]string
+		dest    string
+		perPage int
+		media   string
+		section string
+	}{
+		{"file", nil, nil, "file", 30, "photo,video", "section"},
+		{"env over file", nil, map[string]string{"TMD_DEST": "ROOT/env", "TMD_PER_PAGE": "40"},
+			"env", 40, "photo,video", "env"},
+		{"flag over env", []string{"-d", "ROOT/flag", "-pp", "50"}, map[string]string{"TMD_DEST": "ROOT/env"},
+			"flag", 50, "photo,video", "flag"},
+		{"flag over section", []string{"-m", "video"}, nil, "file", 30, "video", "section"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file, clean := withConfig(t, testConfig, tt.env)
+			defer clean()
+			root := filepath.Dir(file)
+
+			args := []string{}
+			for _, a := range tt.args {
+				args = append(args, strings.Replace(a, "ROOT", root, -1))
+			}
+			cmd, _ := findCommand("download")
+			fs := cmd.flagSet()
+			if err := fs.Parse(args); err != nil {
+				t.Fatal(err)
+			}
+			if err := applyConfig(fs, file); err != nil {
+				t.Fatal(err)
+			}
+
+			if dest != filepath.Join(root, tt.dest) || perPage != tt.perPage || media != tt.media || jitter != 0.5 {
+				t.Errorf("dest = %s, per page = %d, media = %s, jitter = %v", dest, perPage, media, jitter)
+			}
+			if len(blogOrder) != 1 || blogOrder[0] != "sectioned" {
+				t.Fatalf("blog order = %v", blogOrder)
+			}
+
+			// section options are applied after global flags, like download does
+			d, err := tumblr.New(tumblr.Destination(dest))
+			if err != nil {
+				t.Fatal(err)
+			}
+			folder, _, err := d.Folder("sectioned", blogSections["sectioned"]...)
+			if want := filepath.Join(root, tt.section); err != nil || folder != want {
+				t.Errorf("section folder = %s, %v, want %s", folder, err, want)
+			}
+		})
+	}
+}
+
+func TestApplyConfigInvalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		env     map[string]string
+	}{
+		{"unknown key", `colour = "red"`, nil},
+		{"invalid file value", `per_page = "many"`, nil},
+		{"invalid env value", ``, map[string]string{"TMD_PER_PAGE": "many"}},
+		{"section without name", "[[blog]]\ndest = \"/x\"", nil},
+		{"invalid section value", "[[blog]]\nname = \"a\"\nafter = \"yesterday\"", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file, clean := withConfig(t, tt.content, tt.env)
+			defer clean()
+
+			cmd, _ := findCommand("download")
+			if err := applyConfig(cmd.flagSet(), file); err == nil {
+				t.Errorf("applyConfig error = nil")
+			}
+		})
+	}
+}
+
+func TestConfigValue(t *testing.T) {
+	tests := []struct {
+		v    interface{}
+		want string
+	}{
+		{"photo", "photo"},
+		{int64(40), "40"},
+		{0.25, "0.25"},
+		{true, "true"},
+		{time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC), "2020-01-02T03:04:05Z"},
+		{[]interface{}{"photo", "video"}, "photo,video"},
+		{[]interface{}{int64(1), int64(2)}, "1,2"},
+	}
+
+	for _, tt := range tests {
+		if got := configValue(tt.v); got != tt.want {
+			t.Errorf("configValue(%#v) = %s, want %s", tt.v, got, tt.want)
+		}
+	}
+}
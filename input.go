@@ -0,0 +1,276 @@
+// Copyright (c) 2016 - Sarjono Mukti Aji <me@simukti.net>
+// Unless otherwise noted, this source code license is MIT-License
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/simukti/tmd/tumblr"
+)
+
+// following tumblr following export, api /v2/user/following response with or without envelope
+type following struct {
+	Blogs    []followedBlog `json:"blogs"`
+	Response struct {
+		Blogs []followedBlog `json:"blogs"`
+	} `json:"response"`
+}
+
+type followedBlog struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+}
+
+// opml outline list of followed blog feeds, outline without url is a folder
+type opml struct {
+	Body struct {
+		Outlines []opmlOutline `xml:"outline"`
+	} `xml:"body"`
+}
+
+type opmlOutline struct {
+	HTMLURL  string        `xml:"htmlUrl,attr"`
+	XMLURL   string        `xml:"xmlUrl,attr"`
+	Outlines []opmlOutline `xml:"outline"`
+}
+
+// addBlog append blog into download list with its own options,
+// every input is normalized here so the same blog is listed once
+func addBlog(name string, opts []tumblr.Option) error {
+	blog, err := tumblr.ParseBlog(name)
+	if err != nil {
+		return err
+	}
+
+	if _, ok := listOpts[blog]; !ok {
+		list = append(list, blog)
+	}
+	listOpts[blog] = opts
+
+	return nil
+}
+
+// parseList detect input format from file extension or content: json list, following export,
+// opml, csv with header row or plain text with one blog per line
+func parseList(file string, data []byte) error {
+	data = bytes.TrimSpace(data)
+	ext := strings.ToLower(filepath.Ext(file))
+	switch {
+	case len(data) == 0:
+		return errors.New("Input file is empty")
+	case data[0] == '[':
+		return parseJSONList(data)
+	case data[0] == '{':
+		return parseFollowing(data)
+	case data[0] == '<':
+		return parseOPML(data)
+	case ext == ".csv" || csvHeader(data):
+		return parseCSV(data)
+	}
+
+	return parseText(data)
+}
+
+func parseJSONList(data []byte) error {
+	entries := []blogEntry{}
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return errors.New("JSON decoding error, make sure input file contains json list of username or blog object")
+	}
+
+	for _, e := range entries {
+		if strings.TrimSpace(e.Name) == "" {
+			return errors.New("Every blog object in input file must have name")
+		}
+		opts, err := e.options(nil)
+		if err != nil {
+			return fmt.Errorf("[%s] %s", e.Name, err.Error())
+		}
+		if err := addBlog(e.Name, opts); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func parseFollowing(data []byte) error {
+	f := following{}
+	if err := json.Unmarshal(data, &f); err != nil {
+		return errors.New("JSON decoding error, make sure input file is tumblr following export")
+	}
+
+	blogs := append(f.Blogs, f.Response.Blogs...)
+	if len(blogs) == 0 {
+		return errors.New("No blog found in following export")
+	}
+	for _, b := range blogs {
+		name := b.Name
+		if name == "" {
+			name = b.URL
+		}
+		if err := addBlog(name, nil); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func parseOPML(data []byte) error {
+	o := opml{}
+	if err := xml.Unmarshal(data, &o); err != nil {
+		return fmt.Errorf("OPML decoding error [%s]", err.Error())
+	}
+
+	found := 0
+	var walk func(outlines []opmlOutline) error
+	walk = func(outlines []opmlOutline) error {
+		for _, ol := range outlines {
+			u := ol.HTMLURL
+			if u == "" {
+				u = ol.XMLURL
+			}
+			if u != "" {
+				if err := addBlog(u, nil); err != nil {
+					return err
+				}
+				found++
+			}
+			if err := walk(ol.Outlines); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := walk(o.Body.Outlines); err != nil {
+		return err
+	}
+	if found == 0 {
+		return errors.New("No blog found in OPML file")
+	}
+
+	return nil
+}
+
+// csvHeader check if first line which is not comment is csv header with name column
+func csvHeader(data []byte) bool {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		return strings.Contains(line, ",") && strings.HasPrefix(strings.ToLower(line), "name,")
+	}
+
+	return false
+}
+
+// parseCSV read csv with header row, columns are the same as blog object of json list
+func parseCSV(data []byte) error {
+	r := csv.NewReader(bytes.NewReader(data))
+	r.Comment = '#'
+	r.TrimLeadingSpace = true
+
+	header, err := r.Read()
+	if err != nil {
+		return fmt.Errorf("CSV decoding error [%s]", err.Error())
+	}
+	columns := map[string]int{}
+	for i, h := range header {
+		columns[strings.ToLower(strings.TrimSpace(h))] = i
+	}
+	if _, ok := columns["name"]; !ok {
+		return errors.New("CSV header must contain name column")
+	}
+
+	for {
+		row, err := r.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("CSV decoding error [%s]", err.Error())
+		}
+
+		e, err := csvEntry(columns, row)
+		if err != nil {
+			return err
+		}
+		opts, err := e.options(nil)
+		if err != nil {
+			return fmt.Errorf("[%s] %s", e.Name, err.Error())
+		}
+		if err := addBlog(e.Name, opts); err != nil {
+			return err
+		}
+	}
+}
+
+// csvEntry blog object of single csv row, list cell is comma separated
+func csvEntry(columns map[string]int, row []string) (blogEntry, error) {
+	e := blogEntry{}
+	cell := func(name string) string {
+		if i, ok := columns[name]; ok && i < len(row) {
+			return strings.TrimSpace(row[i])
+		}
+		return ""
+	}
+
+	e.Name = cell("name")
+	if e.Name == "" {
+		return e, errors.New("Every csv row must have name")
+	}
+	e.Media = splitList(cell("media"))
+	e.Dest = cell("dest")
+	e.Layout = cell("layout")
+	e.After = cell("after")
+	e.Before = cell("before")
+	e.Tags = splitList(cell("tags"))
+	e.ExcludeTags = splitList(cell("exclude_tags"))
+	e.Reblogs = cell("reblogs")
+
+	if v := cell("limit_page"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return e, fmt.Errorf("[%s] invalid limit_page %s", e.Name, v)
+		}
+		e.LimitPage = &n
+	}
+	if v := cell("per_page"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return e, fmt.Errorf("[%s] invalid per_page %s", e.Name, v)
+		}
+		e.PerPage = n
+	}
+
+	return e, nil
+}
+
+// parseText read one blog per line, empty line and line starting with # is skipped
+func parseText(data []byte) error {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if err := addBlog(line, nil); err != nil {
+			return err
+		}
+	}
+
+	return scanner.Err()
+}
@@ -4,311 +4,273 @@
 package main
 
 import (
+	"bufio"
 	"encoding/json"
-	"encoding/xml"
 	"errors"
 	"flag"
 	"fmt"
-	"github.com/fatih/color"
 	"io"
-	"math"
-	"math/rand"
-	"net/http"
-	"net/url"
+	"io/ioutil"
 	"os"
 	"path/filepath"
-	"regexp"
+	"strconv"
 	"strings"
-	"sync"
 	"time"
-)
-
-const (
-	// BASEURL main tumblr user api base domain
-	BASEURL = "http://%s.tumblr.com"
-
-	// APIURL main tumblr user api full path
-	APIURL = "%s/api/read?type=%s&num=%d&start=%d"
-
-	// DEFAULTSTART default start post number
-	DEFAULTSTART = 0
-
-	// DEFAULTDTO default download timeout
-	DEFAULTDTO = 3600
-
-	// DEFAULTCTO default connect timeout, this is used on first request to get total posts
-	DEFAULTCTO = 15
-
-	// DEFAULTBATCH default files download at once
-	DEFAULTBATCH = 2
-
-	// DEFAULTPERPAGE default posts per page request
-	DEFAULTPERPAGE = 20
-
-	// MAXPERBATCH maximum files download at once
-	MAXPERBATCH = 10
-
-	// MAXPERPAGE maximum posts perpage request
-	MAXPERPAGE = 40
 
-	// DEFAULTMEDIA default value for cli -m param
-	DEFAULTMEDIA = "all"
-
-	// PHOTO photo post type
-	PHOTO = "photo"
-
-	// VIDEO video post type
-	VIDEO = "video"
-
-	// BYTE byte unit float
-	BYTE = 1.0
-
-	// KiB Kibibyte
-	KiB = 1024 * BYTE
-
-	// MiB Mebibyte
-	MiB = 1024 * KiB
-
-	// GiB Gibibyte
-	GiB = 1024 * MiB
+	"github.com/fatih/color"
+	"github.com/simukti/tmd/tumblr"
 )
 
 var (
-	input         string
-	uname         string
-	dest          string
-	media         string
-	list          []string
-	batch         int
-	cto           int
-	dto           int
-	perPage       int
-	limitPage     int
-	counterFile   int
-	counterPost   int
-	counterSize   int64
-	counterStored int64
-	allowedMedia  = map[string]bool{"all": true, PHOTO: true, VIDEO: true}
-	allMedia      = []string{PHOTO, VIDEO}
-
-	// every http request will randomly pick one user agent from this string list
-	defaultUserAgents = [...]string{
-		"Mozilla/5.0 (Macintosh; Intel Mac OS X 10.11; rv:48.0) Gecko/20100101 Firefox/48.0",
-		"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_11_6) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/40.0.2214.91 Safari/537.36",
-		"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_11_6) AppleWebKit/601.7.7 (KHTML, like Gecko) Version/9.1.2 Safari/601.7.7",
-		"Mozilla/5.0 (Linux; Android 5.0.2; LG-V410/V41020c Build/LRX22G) AppleWebKit/537.36 (KHTML, like Gecko) Version/4.0 Chrome/34.0.1847.118 Safari/537.36",
-		"Mozilla/5.0 (Linux; Android 6.0.1; Nexus 6P Build/MMB29P) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/47.0.2526.83 Mobile Safari/537.36",
-		"Mozilla/5.0 (Linux; Android 5.1.1; SM-G928X Build/LMY47X) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/47.0.2526.83 Mobile Safari/537.36",
-		"Mozilla/5.0 (Linux; Android 5.0.2; SAMSUNG SM-T550 Build/LRX22G) AppleWebKit/537.36 (KHTML, like Gecko) SamsungBrowser/3.3 Chrome/38.0.2125.102 Safari/537.36",
-		"Mozilla/5.0 (Linux; Android 5.0.2; LG-V410/V41020c Build/LRX22G) AppleWebKit/537.36 (KHTML, like Gecko) Version/4.0 Chrome/34.0.1847.118 Safari/537.36",
-		"Mozilla/5.0 (Windows NT 6.1; WOW64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/47.0.2526.111 Safari/537.36",
-		"Mozilla/5.0 (Windows Phone 10.0; Android 4.2.1; Microsoft; Lumia 950) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/46.0.2486.0 Mobile Safari/537.36 Edge/13.10586",
-	}
+	input     string
+	uname     string
+	dest      string
+	media     string
+	list      []string
+	batch     int
+	queue     int
+	cto       int
+	dto       int
+	perPage   int
+	limitPage int
+	api       string
+	apiKey    string
+	sinceLast bool
+	pageTries int
+	pageWait  int
+	fileTries int
+	fileWait  int
+	jitter    float64
+	metadata  string
+	photoSize string
+	photoOrig bool
+	embedCmd  string
+	layout    string
+	after     string
+	before    string
+	tags      string
+	xtags     string
+	originals bool
+	reblogs   bool
+	posts     string
+	postFile  string
+	config    string
+	dryRun    bool
+	planFile  string
+	postIDs   = map[string][]string{}
+	listOpts  = map[string][]tumblr.Option{}
+
+	// flags which may be empty
+	optional = map[string]bool{"key": true, "embed-cmd": true, "after": true, "before": true, "tag": true, "exclude-tag": true, "p": true, "pf": true, "config": true, "plan": true}
 )
 
-// Tumblr parent xml result
-type Tumblr struct {
-	TumbleBlog TumbleBlog `xml:"tumblelog"`
-	Posts      Posts      `xml:"posts"`
-}
-
-// TumbleBlog detail of current tumblr blog
-type TumbleBlog struct {
-	Name      string `xml:"name,attr"`
-	Timezone  string `xml:"timezone,attr"`
-	Canonical string `xml:"cname,attr"`
-}
-
-// Posts entities of current page
-type Posts struct {
-	Type  string `xml:"type,attr"`
-	Start int    `xml:"start,attr"`
-	Total int    `xml:"total,attr"`
-	Posts []Post `xml:"post"`
-}
-
-// Post single entity which contain photo/video list
-type Post struct {
-	ID            string        `xml:"id,attr"`
-	Timestamp     int           `xml:"unix-timestamp,attr"`
-	Type          string        `xml:"type,attr"`
-	Slug          string        `xml:"slug,attr"`
-	PhotoURLs     []PhotoURL    `xml:"photo-url"`         // only available in photo media type
-	PhotoSet      Photoset      `xml:"photoset"`          // only available in photo media type (optional)
-	IsDirectVideo bool          `xml:"direct-video,attr"` // only available in video media type
-	VideoPlayer   []VideoPlayer `xml:"video-player"`      // only available in video media type
-	VideoSource   VideoSource   `xml:"video-source"`      // only available in video media type
-}
-
-// VideoSource single detail on VideoPlayer
-type VideoSource struct {
-	ContentType string `xml:"content-type"`
-	Extension   string `xml:"extension"`
-	Width       int    `xml:"width"`
-	Height      int    `xml:"height"`
-	Duration    int    `xml:"duration"`
-}
-
-// VideoPlayer entity which contain video file url
-type VideoPlayer struct {
-	MaxWidth int    `xml:"max-width,attr"`
-	Content  string `xml:",chardata"`
-}
-
-// PhotoURL entity which contain photo file url
-type PhotoURL struct {
-	MaxWidth int    `xml:"max-width,attr"`
-	FileURL  string `xml:",chardata"`
-}
-
-// Photoset optional entity which can be exists on photo Post
-type Photoset struct {
-	Photo []Photo `xml:"photo"`
-}
-
-// Photo entities which exists on Photoset, this contains photo file urls
-type Photo struct {
-	PhotoURL []PhotoURL `xml:"photo-url"`
-}
+// downloadFlags register flags of download and sync command
+func downloadFlags(fs *flag.FlagSet) {
+	// default destination folder is current exexutable dir
+	defaultDest, _ := os.Getwd()
 
-// tumblrJob main tumblr download jobs per username and media
-type tumblrJob struct {
-	username        string
-	mainFolder      string
-	media           string
-	batch           int
-	connectTimeout  int
-	downloadTimeout int
-	start           int
-	perPage         int
-	limitPage       int
+	fs.StringVar(&config, "config", os.Getenv("TMD_CONFIG"), "TOML config file, see README for keys, default from TMD_CONFIG env")
+	fs.StringVar(&input, "s", ".", "Input file of blog list: json, csv, plain text, opml or following export (see README), - is stdin")
+	fs.StringVar(&uname, "u", ".", "Tumblr username, blog url or custom domain to download -- comma separated for multiple username")
+	fs.StringVar(&posts, "p", "", "Only download these posts, post url or id (require single -u) -- comma separated for multiple post")
+	fs.StringVar(&postFile, "pf", "", "Only download posts listed in this file, one post url or id per line")
+	fs.StringVar(&dest, "d", defaultDest, "Destination directory")
+	fs.StringVar(&media, "m", tumblr.DEFAULTMEDIA, "Media type to download -- comma separated for multiple media type")
+	fs.IntVar(&batch, "b", tumblr.DEFAULTBATCH, "Files downloaded at once")
+	fs.IntVar(&queue, "q", tumblr.DEFAULTQUEUE, "Max files waiting for download, page fetching pause while it is full")
+	fs.IntVar(&cto, "cto", tumblr.DEFAULTCTO, "Connect timeout on XML parsing")
+	fs.IntVar(&dto, "dto", tumblr.DEFAULTDTO, "Download timeout per file")
+	fs.IntVar(&perPage, "pp", tumblr.DEFAULTPERPAGE, "Default post per page")
+	fs.IntVar(&limitPage, "lp", 0, "Max page to fetch, 0 is unlimited (all page)")
+	fs.StringVar(&after, "after", "", "Only posts published at or after this time, 2006-01-02, RFC3339 or unix timestamp")
+	fs.StringVar(&before, "before", "", "Only posts published before this time, 2006-01-02, RFC3339 or unix timestamp")
+	fs.StringVar(&tags, "tag", "", "Only posts with any of these tags, comma separated")
+	fs.StringVar(&xtags, "exclude-tag", "", "Skip posts with any of these tags, comma separated")
+	fs.BoolVar(&originals, "originals-only", false, "Skip reblogged posts, only posts authored by the blog")
+	fs.BoolVar(&reblogs, "reblogs-only", false, "Only reblogged posts, reblog source is written in metadata")
+	fs.StringVar(&api, "api", "xml", "Tumblr api backend, xml (legacy /api/read) or json (api v2, require -key)")
+	fs.StringVar(&apiKey, "key", os.Getenv("TUMBLR_API_KEY"), "Tumblr api v2 key (OAuth consumer key), default from TUMBLR_API_KEY env")
+	fs.IntVar(&pageTries, "page-retry", tumblr.DefaultPageRetry.Attempts, "Attempts per api page on temporary error, 1 is no retry")
+	fs.IntVar(&pageWait, "page-backoff", int(tumblr.DefaultPageRetry.Backoff.Seconds()), "Seconds before first api page retry, doubled on every next retry")
+	fs.IntVar(&fileTries, "media-retry", tumblr.DefaultMediaRetry.Attempts, "Attempts per media file on temporary error, 1 is no retry")
+	fs.IntVar(&fileWait, "media-backoff", int(tumblr.DefaultMediaRetry.Backoff.Seconds()), "Seconds before first media file retry, doubled on every next retry")
+	fs.Float64Var(&jitter, "jitter", tumblr.DefaultMediaRetry.Jitter, "Random fraction (0.0 - 1.0) of every retry delay added or removed")
+	fs.StringVar(&metadata, "meta", tumblr.METANONE, "Post metadata output: none, post (json sidecar per post) or jsonl (posts.jsonl per blog)")
+	fs.StringVar(&embedCmd, "embed-cmd", "", "Command called with url of every new embedded video (youtube, vimeo, ...) as last argument")
+	fs.StringVar(&layout, "layout", tumblr.DEFAULTLAYOUT, "Media file path template relative to -d, see README for fields")
+	fs.StringVar(&photoSize, "photo", tumblr.PHOTOLARGEST, "Photo size: largest, max width (e.g. 1280) or comma separated widths tried in order (e.g. 1280,500)")
+	fs.BoolVar(&photoOrig, "photo-original", false, "Try original and 1280 url of tumblr hosted photo first, fall back on 403/404")
+	fs.BoolVar(&sinceLast, "since-last", false, "Stop at the newest post already archived on last finished run")
+	fs.BoolVar(&dryRun, "dry-run", false, "Only list files which would be downloaded with their destination, nothing is written")
+	fs.StringVar(&planFile, "plan", "", "Write every file listed on dry run as json line into this file, imply -dry-run")
 }
 
-func init() {
-	// default destination folder is current exexutable dir
-	defaultDest, _ := os.Getwd()
+// parseDownload parse download flags, config file and blog list
+func parseDownload(fs *flag.FlagSet, args []string) error {
+	if err := fs.Parse(args); err != nil {
+		return flagError{err}
+	}
+	if err := applyConfig(fs, config); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return usageError{fmt.Errorf("Unexpected argument %s", fs.Arg(0))}
+	}
 
-	flag.StringVar(&input, "s", ".", "JSON input file")
-	flag.StringVar(&uname, "u", ".", "Tumblr username to download, WITHOUT ending .tumblr.com ! -- comma separated for multiple username")
-	flag.StringVar(&dest, "d", defaultDest, "Destination directory")
-	flag.StringVar(&media, "m", DEFAULTMEDIA, "Media type to download")
-	flag.IntVar(&batch, "b", DEFAULTBATCH, "File per download")
-	flag.IntVar(&cto, "cto", DEFAULTCTO, "Connect timeout on XML parsing")
-	flag.IntVar(&dto, "dto", DEFAULTDTO, "Download timeout per n file batch in param -b")
-	flag.IntVar(&perPage, "pp", DEFAULTPERPAGE, "Default post per page")
-	flag.IntVar(&limitPage, "lp", 0, "Max page to fetch, 0 is unlimited (all page)")
-	flag.Parse()
-	flag.VisitAll(func(f *flag.Flag) {
-		if f.Value.String() == "" {
-			msg := color.New(color.FgHiRed, color.Bold).
-				SprintfFunc()("[ERROR] Flag param -%s is required", f.Name)
-			fmt.Println(msg)
-			fmt.Println("Usage:")
-			flag.PrintDefaults()
-			os.Exit(0)
+	required := ""
+	fs.VisitAll(func(f *flag.Flag) {
+		if required == "" && f.Value.String() == "" && !optional[f.Name] {
+			required = f.Name
 		}
 	})
-
-	if !allowedMedia[media] {
-		am := []string{}
-		for m := range allowedMedia {
-			am = append(am, m)
-		}
-		msg := color.New(color.FgHiRed, color.Bold).
-			SprintfFunc()("[ERROR] Allowed media is: %s", strings.Join(am, ","))
-		fmt.Println(msg)
-		os.Exit(0)
+	if required != "" {
+		return usageError{fmt.Errorf("Flag param -%s is required", required)}
 	}
 
-	if uname == "." && input == "." {
-		msg := color.New(color.FgHiRed, color.Bold).
-			SprintfFunc()("[ERROR] Flag param -u (username comma separated) OR -s (json file) IS required !")
-		fmt.Println(msg)
-		fmt.Println("Usage:")
-		flag.PrintDefaults()
-		os.Exit(0)
+	if uname == "." && input == "." && posts == "" && postFile == "" && len(blogOrder) == 0 {
+		return usageError{errors.New("Flag param -u (username comma separated) OR -s (json file) OR -p/-pf (post) OR [[blog]] in config IS required !")}
 	}
 
 	if uname != "." {
-		sp := strings.Split(uname, ",")
-		for _, su := range sp {
-			if su != "" {
-				list = append(list, strings.TrimSpace(strings.ToLower(su)))
+		for _, su := range splitList(uname) {
+			if err := addBlog(su, nil); err != nil {
+				return err
 			}
 		}
-	} else {
+	} else if input != "." {
 		if err := loadList(input); err != nil {
-			msg := color.New(color.FgHiRed, color.Bold).
-				SprintfFunc()("[ERROR] %s", err.Error())
-			fmt.Println(msg)
-			os.Exit(0)
+			return err
+		}
+	} else {
+		for _, b := range blogOrder {
+			if err := addBlog(b, nil); err != nil {
+				return err
+			}
 		}
 	}
 
-	if err := checkDest(dest); err != nil {
-		msg := color.New(color.FgHiRed, color.Bold).
-			SprintfFunc()("[ERROR] %s", err.Error())
-		fmt.Println(msg)
-		os.Exit(0)
-
+	if posts != "" || postFile != "" {
+		return loadPosts(posts, postFile)
 	}
 
-	if batch < 1 {
-		batch = 1
+	return nil
+}
+
+// runDownload download every listed blog, sync force -since-last
+func runDownload(cmd command, args []string) int {
+	fs := cmd.flagSet()
+	if err := parseDownload(fs, args); err != nil {
+		return cmd.fail(fs, err)
+	}
+	if cmd.name == "sync" {
+		sinceLast = true
 	}
 
-	if batch > MAXPERBATCH {
-		batch = MAXPERBATCH
+	backend, err := apiBackend(api, apiKey)
+	if err != nil {
+		printError("[ERROR] %s", err.Error())
+		return EXITUSAGE
 	}
 
-	if perPage < 1 {
-		perPage = DEFAULTPERPAGE
+	afterTime, err := parseTime(after)
+	if err != nil {
+		printError("[ERROR] -after %s", err.Error())
+		return EXITUSAGE
+	}
+	beforeTime, err := parseTime(before)
+	if err != nil {
+		printError("[ERROR] -before %s", err.Error())
+		return EXITUSAGE
 	}
 
-	if perPage > MAXPERPAGE {
-		perPage = MAXPERPAGE
+	reblogMode := tumblr.REBLOGSALL
+	if originals && reblogs {
+		printError("[ERROR] -originals-only and -reblogs-only can not be used together")
+		return EXITUSAGE
+	}
+	if originals {
+		reblogMode = tumblr.REBLOGSNONE
+	}
+	if reblogs {
+		reblogMode = tumblr.REBLOGSONLY
 	}
 
-	if cto < 1 {
-		cto = DEFAULTCTO
+	if pageWait < 0 || fileWait < 0 {
+		printError("[ERROR] -page-backoff and -media-backoff can not be negative")
+		return EXITUSAGE
 	}
+	pageRetry := tumblr.DefaultPageRetry
+	pageRetry.Attempts = pageTries
+	pageRetry.Backoff = time.Second * time.Duration(pageWait)
+	pageRetry.Jitter = jitter
+	mediaRetry := tumblr.DefaultMediaRetry
+	mediaRetry.Attempts = fileTries
+	mediaRetry.Backoff = time.Second * time.Duration(fileWait)
+	mediaRetry.Jitter = jitter
 
-	if dto < 1 {
-		dto = DEFAULTDTO
+	var planOut io.Writer
+	if planFile != "" {
+		f, err := os.Create(planFile)
+		if err != nil {
+			printError("[ERROR] -plan %s", err.Error())
+			return EXITUSAGE
+		}
+		defer f.Close()
+		planOut, dryRun = f, true
+	}
+
+	downloader, err := tumblr.New(
+		tumblr.API(backend),
+		tumblr.PageRetry(pageRetry),
+		tumblr.MediaRetry(mediaRetry),
+		tumblr.Destination(dest),
+		tumblr.Media(media),
+		tumblr.Batch(batch),
+		tumblr.QueueSize(queue),
+		tumblr.ConnectTimeout(cto),
+		tumblr.DownloadTimeout(dto),
+		tumblr.PerPage(perPage),
+		tumblr.LimitPage(limitPage),
+		tumblr.SinceLast(sinceLast),
+		tumblr.Metadata(metadata),
+		tumblr.PhotoSize(photoSize),
+		tumblr.PhotoOriginal(photoOrig),
+		tumblr.EmbedCommand(embedCmd),
+		tumblr.Layout(layout),
+		tumblr.After(afterTime),
+		tumblr.Before(beforeTime),
+		tumblr.Tags(splitList(tags)...),
+		tumblr.ExcludeTags(splitList(xtags)...),
+		tumblr.Reblogs(reblogMode),
+		tumblr.DryRun(dryRun),
+		tumblr.PlanOutput(planOut),
+	)
+	if err != nil {
+		printError("[ERROR] %s", err.Error())
+		return EXITUSAGE
 	}
-}
 
-func main() {
 	absDest, _ := filepath.Abs(dest)
 	fmt.Println(color.GreenString("[SAVE TO] %s/*", absDest))
-	// mulai dari nol ya mbak...
-	counterFile = 0
-	counterPost = 0
 	startTime := time.Now()
+	failedBlogs := 0
 
 	for _, username := range list {
-		job := &tumblrJob{
-			username:        username,
-			mainFolder:      dest,
-			media:           media,
-			batch:           batch,
-			start:           DEFAULTSTART,
-			perPage:         perPage,
-			limitPage:       limitPage,
-			connectTimeout:  cto,
-			downloadTimeout: dto,
+		opts := append([]tumblr.Option{}, blogSections[username]...)
+		opts = append(opts, listOpts[username]...)
+		if ids, ok := postIDs[username]; ok {
+			opts = append(opts, tumblr.PostIDs(ids...))
 		}
-
-		if err := job.processJob(); err != nil {
-			msg := color.New(color.FgHiRed, color.Bold).
-				SprintfFunc()("[ERROR] %s", err.Error())
-			fmt.Println(msg)
+		if err := downloader.Download(username, opts...); err != nil {
+			failedBlogs++
+			printError("[ERROR] %s", err.Error())
 		}
 	}
 
+	stats := downloader.Stats()
 	processedUsers := strings.Join(list, ",")
-	totalDownloaded := float32(counterSize) / GiB
-	totalStored := float32(counterStored) / GiB
+	totalDownloaded := float32(stats.Downloaded) / tumblr.GiB
+	totalStored := float32(stats.Stored) / tumblr.GiB
 	totalTime := time.Since(startTime).Seconds()
 	summary := color.New(color.FgHiYellow, color.Bold).
 		SprintfFunc()(""+
@@ -318,39 +280,55 @@ func main() {
 		"\n[FILE] %d files (%.3f GiB)"+
 		"\n[SIZE] %.3f GiB downloaded"+
 		"\n[TIME] %.2f seconds"+
+		"\n[FAIL] %d blogs, %d pages, %d files, %d embed commands"+
 		"\n--------",
 		processedUsers,
-		counterPost,
-		counterFile,
+		stats.Posts,
+		stats.Files,
 		totalStored,
 		totalDownloaded,
 		totalTime,
+		failedBlogs,
+		stats.FailedPage,
+		stats.FailedFile,
+		stats.FailedEmbed,
 	)
 
 	fmt.Println(summary)
-}
-
-func checkDest(dir string) error {
-	abs, absErr := filepath.Abs(dir)
-	if absErr != nil {
-		return fmt.Errorf("Unable to parse %s", abs)
+	if dryRun {
+		fmt.Println(color.GreenString("[PLAN] %d files to download, nothing is written", stats.Planned))
 	}
-
-	s, sErr := os.Stat(abs)
-	if sErr != nil {
-		if os.IsNotExist(sErr) {
-			return fmt.Errorf("Destination folder '%s' not found, create first, I'll do the rest", abs)
-		}
+	if failedBlogs > 0 || stats.FailedPage > 0 || stats.FailedFile > 0 || stats.FailedEmbed > 0 {
+		return EXITFAILED
 	}
 
-	if !s.IsDir() {
-		return fmt.Errorf("Destination '%s' is not a folder", abs)
+	return EXITOK
+}
+
+func apiBackend(name, key string) (tumblr.Backend, error) {
+	switch name {
+	case "xml":
+		return tumblr.XMLBackend{}, nil
+	case "json":
+		if key == "" {
+			return nil, errors.New("Flag param -key is required for json api")
+		}
+		return tumblr.JSONBackend{APIKey: key}, nil
 	}
 
-	return nil
+	return nil, errors.New("Allowed api is: xml,json")
 }
 
+// loadList read blog list from input file, - is stdin, see parseList for formats
 func loadList(file string) error {
+	if file == "-" {
+		data, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			return err
+		}
+		return parseList(file, data)
+	}
+
 	abs, absErr := filepath.Abs(file)
 	if absErr != nil {
 		return fmt.Errorf("Unable to parse %s", abs)
@@ -361,414 +339,194 @@ func loadList(file string) error {
 		if os.IsNotExist(sErr) {
 			return fmt.Errorf("Input file %s not found", abs)
 		}
+		return sErr
 	}
 
 	if cur, _ := os.Getwd(); cur == abs || s.IsDir() {
 		return errors.New("Input file not found")
 	}
 
-	r, rErr := os.Open(abs)
+	data, rErr := ioutil.ReadFile(abs)
 	if rErr != nil {
 		return fmt.Errorf("Input file %s cannot be opened", abs)
 	}
-	defer r.Close()
 
-	if err := json.NewDecoder(r).Decode(&list); err != nil {
-		return errors.New("JSON decoding error, make sure input file contains json string list")
-	}
+	return parseList(abs, data)
+}
 
-	for k, u := range list {
-		list[k] = strings.TrimSpace(strings.ToLower(u))
+// blogEntry single blog of json list, csv row or [[blog]] section of config file,
+// it is either username string or object which override global flags for that blog only
+type blogEntry struct {
+	Name        string     `json:"name" toml:"name"`
+	Media       stringList `json:"media" toml:"media"`
+	Dest        string     `json:"dest" toml:"dest"`
+	Layout      string     `json:"layout" toml:"layout"`
+	LimitPage   *int       `json:"limit_page" toml:"limit_page"`
+	PerPage     int        `json:"per_page" toml:"per_page"`
+	After       string     `json:"after" toml:"after"`
+	Before      string     `json:"before" toml:"before"`
+	Tags        stringList `json:"tags" toml:"tags"`
+	ExcludeTags stringList `json:"exclude_tags" toml:"exclude_tags"`
+	Reblogs     string     `json:"reblogs" toml:"reblogs"`
+}
+
+func (e *blogEntry) UnmarshalJSON(b []byte) error {
+	name := ""
+	if json.Unmarshal(b, &name) == nil {
+		e.Name = name
+		return nil
 	}
 
-	return nil
+	type entry blogEntry
+	return json.Unmarshal(b, (*entry)(e))
 }
 
-func (job *tumblrJob) processJob() error {
-	useragent := defaultUserAgents[rand.Intn(len(defaultUserAgents))]
-	client := http.DefaultClient
-	userURL := fmt.Sprintf(BASEURL, job.username)
-	headReq, _ := http.NewRequest("HEAD", userURL, nil) // check username existence
-	headReq.Header.Set("User-Agent", useragent)
-	headResp, headErr := client.Do(headReq)
-	headError := fmt.Errorf("Unable to parse %s", userURL)
-	if headErr != nil {
-		return headError
+// options download options of entry, empty field and field of flag in given keep global flag value
+func (e blogEntry) options(given map[string]bool) ([]tumblr.Option, error) {
+	opts := []tumblr.Option{}
+	if len(e.Media) > 0 && !given["m"] {
+		opts = append(opts, tumblr.Media(strings.Join(e.Media, ",")))
 	}
-	defer headResp.Body.Close()
-
-	if headResp.StatusCode != http.StatusOK {
-		return headError
+	if e.Dest != "" && !given["d"] {
+		opts = append(opts, tumblr.Destination(e.Dest))
 	}
-
-	mediaType := []string{}
-
-	if job.media == "all" {
-		mediaType = allMedia
-	} else {
-		mediaType = append(mediaType, job.media)
+	if e.Layout != "" && !given["layout"] {
+		opts = append(opts, tumblr.Layout(e.Layout))
 	}
-
-	userDir := filepath.Join(job.mainFolder, job.username)
-	if _, cErr := os.Stat(userDir); os.IsNotExist(cErr) {
-		if err := os.Mkdir(filepath.Join(job.mainFolder, job.username), 0700); err != nil {
-			return err
-		}
+	if e.LimitPage != nil && !given["lp"] {
+		opts = append(opts, tumblr.LimitPage(*e.LimitPage))
 	}
-
-	for _, m := range mediaType {
-		job.media = m
-		mJob := &mediaJob{
-			userURL: userURL,
-			mainJob: job,
-		}
-
-		mediaDir := filepath.Join(job.mainFolder, job.username, m)
-		if _, mErr := os.Stat(mediaDir); os.IsNotExist(mErr) {
-			if err := os.Mkdir(mediaDir, 0700); err != nil {
-				return err
-			}
+	if e.PerPage > 0 && !given["pp"] {
+		opts = append(opts, tumblr.PerPage(e.PerPage))
+	}
+	if e.After != "" && !given["after"] {
+		t, err := parseTime(e.After)
+		if err != nil {
+			return nil, fmt.Errorf("after %s", err.Error())
 		}
-
-		if err := mJob.processMedia(); err != nil {
-			msg := color.New(color.FgHiRed, color.Bold).
-				SprintfFunc()("[ERROR] %s", err.Error())
-			// don't cancel job
-			fmt.Println(msg)
+		opts = append(opts, tumblr.After(t))
+	}
+	if e.Before != "" && !given["before"] {
+		t, err := parseTime(e.Before)
+		if err != nil {
+			return nil, fmt.Errorf("before %s", err.Error())
 		}
+		opts = append(opts, tumblr.Before(t))
+	}
+	if len(e.Tags) > 0 && !given["tag"] {
+		opts = append(opts, tumblr.Tags(e.Tags...))
+	}
+	if len(e.ExcludeTags) > 0 && !given["exclude-tag"] {
+		opts = append(opts, tumblr.ExcludeTags(e.ExcludeTags...))
+	}
+	if e.Reblogs != "" && !given["originals-only"] && !given["reblogs-only"] {
+		opts = append(opts, tumblr.Reblogs(e.Reblogs))
 	}
 
-	return nil
-}
-
-type mediaJob struct {
-	userURL string
-	mainJob *tumblrJob
+	return opts, nil
 }
 
-func (m *mediaJob) processMedia() error {
-	ping := fmt.Sprintf(APIURL, m.userURL, m.mainJob.media, 0, 0)
-	blog, err := getXMLSource(ping, m.mainJob.connectTimeout)
-	if err != nil {
-		return err
-	}
-
-	currentPage := 0
-	startAt := m.mainJob.start
-	countTotal := float64(blog.Posts.Total) / float64(m.mainJob.perPage)
-	totalPage := int(math.Ceil(countTotal))
+// stringList json string list, single comma separated string is accepted too
+type stringList []string
 
-	if m.mainJob.limitPage != 0 && m.mainJob.limitPage < totalPage {
-		if m.mainJob.limitPage < 0 {
-			totalPage = 1
-			fmt.Println(color.GreenString("[INFO] REVERT LIMIT PAGE TO: %d", totalPage))
-		} else {
-			totalPage = m.mainJob.limitPage
-			fmt.Println(color.GreenString("[INFO] SET LIMIT PAGE TO: %d", totalPage))
-		}
-	} else {
-		fmt.Println(color.GreenString("[INFO] TOTAL PAGE: %d", totalPage))
+func (l *stringList) UnmarshalJSON(b []byte) error {
+	s := ""
+	if json.Unmarshal(b, &s) == nil {
+		*l = splitList(s)
+		return nil
 	}
 
-	for currentPage < totalPage {
-		currentPage++
-		startAt = (m.mainJob.perPage * (currentPage - 1)) + 1
-		if startAt == 1 {
-			startAt = 0
-		}
-		api := fmt.Sprintf(APIURL, m.userURL, m.mainJob.media, m.mainJob.perPage, startAt)
-		blogPage, pageErr := getXMLSource(api, m.mainJob.connectTimeout)
-
-		if pageErr != nil {
-			msg := color.New(color.FgHiRed, color.Bold).
-				SprintfFunc()("[ERROR PAGE %d] [%s]", currentPage, pageErr.Error())
-			fmt.Println(msg)
-		} else {
-			fmt.Println(
-				color.CyanString(
-					"\n====================================[%s] [%s] [PAGE %d/%d]====================================",
-					strings.ToUpper(fmt.Sprintf(BASEURL, m.mainJob.username)),
-					strings.ToUpper(m.mainJob.media),
-					currentPage,
-					totalPage,
-				))
-
-			blogPage.processPage(m.mainJob.mainFolder, m.mainJob.batch, m.mainJob.downloadTimeout)
-		}
+	items := []string{}
+	if err := json.Unmarshal(b, &items); err != nil {
+		return err
 	}
+	*l = items
 
 	return nil
 }
 
-func getXMLSource(api string, cto int) (*Tumblr, error) {
-	t := &Tumblr{}
-	useragent := defaultUserAgents[rand.Intn(len(defaultUserAgents))]
-	client := &http.Client{Timeout: (time.Second * time.Duration(cto))}
-	apiReq, _ := http.NewRequest("GET", api, nil)
-	apiReq.Header.Set("User-Agent", useragent)
-	apiResp, apiErr := client.Do(apiReq)
-	if apiErr != nil {
-		return t, apiErr
-	}
-	defer apiResp.Body.Close()
-	err := xml.NewDecoder(apiResp.Body).Decode(t)
-
-	return t, err
-}
-
-func (t *Tumblr) processPage(mainTargetFolder string, perBatch, dto int) bool {
-	fl := []*fileToDownload{}
-	for _, p := range t.Posts.Posts {
-		counterPost++
+// loadPosts group post urls or ids from flag and file by blog, blog list is replaced by blogs of these posts.
+// Bare post id belong to the only username given by -u.
+func loadPosts(arg, file string) error {
+	items := splitList(arg)
+	if file != "" {
+		r, err := os.Open(file)
+		if err != nil {
+			return fmt.Errorf("Post file %s cannot be opened", file)
+		}
+		defer r.Close()
 
-		if p.Type == PHOTO {
-			pfj := t.getPhotoFileJob(&p, mainTargetFolder)
-			fl = append(fl, pfj...)
+		scanner := bufio.NewScanner(r)
+		for scanner.Scan() {
+			if line := strings.TrimSpace(scanner.Text()); line != "" && !strings.HasPrefix(line, "#") {
+				items = append(items, line)
+			}
 		}
-		if p.Type == VIDEO {
-			vfj := t.getVideoFileJob(&p, mainTargetFolder)
-			fl = append(fl, vfj...)
+		if err := scanner.Err(); err != nil {
+			return err
 		}
 	}
 
-	dl := downloadList{list: fl, perBatch: perBatch, dto: dto, uname: t.TumbleBlog.Name, media: t.Posts.Type}
-	return dl.process()
-}
-
-func (t *Tumblr) getPhotoFileJob(p *Post, mainTargetFolder string) []*fileToDownload {
-	fl := []*fileToDownload{}
-
-	if len(p.PhotoSet.Photo) > 0 {
-		for _, psp := range p.PhotoSet.Photo {
-			for _, pu := range psp.PhotoURL {
-				if fd, ok := pu.normalizePhotoURL(mainTargetFolder, t.TumbleBlog.Name, p); ok {
-					fl = append(fl, fd)
-				}
-			}
+	blogs := []string{}
+	seen := map[string]bool{}
+	for _, item := range items {
+		blog, id, err := tumblr.ParsePost(item)
+		if err != nil {
+			return err
 		}
-	} else {
-		for _, pu := range p.PhotoURLs {
-			if fd, ok := pu.normalizePhotoURL(mainTargetFolder, t.TumbleBlog.Name, p); ok {
-				fl = append(fl, fd)
+		if blog == "" {
+			if len(list) != 1 {
+				return fmt.Errorf("Post id %s require single -u username", id)
 			}
+			blog = list[0]
 		}
-	}
-
-	return fl
-}
-
-func (pu *PhotoURL) normalizePhotoURL(mainfolder, username string, p *Post) (*fileToDownload, bool) {
-	if pu.MaxWidth == 1280 {
-		fname := normalizeDestination(pu.FileURL, p.ID, p.Timestamp)
-		fd := fileToDownload{
-			url:      pu.FileURL,
-			destFile: filepath.Join(mainfolder, username, p.Type, fname),
+		if seen[blog+"/"+id] {
+			continue
 		}
+		seen[blog+"/"+id] = true
 
-		return &fd, true
-	}
-
-	return &fileToDownload{}, false
-}
-
-func (t *Tumblr) getVideoFileJob(p *Post, mainTargetFolder string) []*fileToDownload {
-	fl := []*fileToDownload{}
-
-	for _, vp := range p.VideoPlayer {
-		// only direct video will be downloaded
-		if vp.MaxWidth == 0 && p.IsDirectVideo {
-			rgx := regexp.MustCompile(`<source[^>]+\bsrc=["']([^"']+)["']`)
-			match := rgx.FindStringSubmatch(vp.Content)
-			if len(match) == 2 {
-				videoURL := match[1]
-				fname := fmt.Sprintf(
-					"%s.%s",
-					normalizeDestination(videoURL, p.ID, p.Timestamp),
-					p.VideoSource.Extension,
-				)
-
-				fd := fileToDownload{
-					url:      videoURL,
-					destFile: filepath.Join(mainTargetFolder, t.TumbleBlog.Name, p.Type, fname),
-				}
-				fl = append(fl, &fd)
-			}
+		if _, ok := postIDs[blog]; !ok {
+			blogs = append(blogs, blog)
 		}
+		postIDs[blog] = append(postIDs[blog], id)
 	}
 
-	return fl
-}
-
-func normalizeDestination(sourceURL string, id string, timestamp int) string {
-	fu, _ := url.Parse(sourceURL)
-	psplit := strings.Split(fu.Path, "/")
-	fname := fmt.Sprintf("%s_%d_%s", id, timestamp, psplit[len(psplit)-1])
-
-	return fname
-}
-
-type fileToDownload struct {
-	url      string
-	destFile string
-}
+	if len(blogs) == 0 {
+		return errors.New("No post url or id found")
+	}
+	list = blogs
 
-type downloadList struct {
-	media    string
-	uname    string
-	perBatch int
-	dto      int
-	list     []*fileToDownload
+	return nil
 }
 
-// process download per batch concurrently
-func (dl *downloadList) process() bool {
-	lenList := len(dl.list)
-	countBatch := float64(lenList) / float64(dl.perBatch)
-	totalBatch := int(math.Ceil(countBatch))
-	if totalBatch < 1 {
-		return false
-	}
-
-	currentBatch := 0
-	start := 0
-	end := 0
-
-	for currentBatch < totalBatch {
-		currentBatch++
-		if currentBatch == 1 {
-			if totalBatch == 1 {
-				end = lenList - 1
-			} else {
-				end = start + (start + (dl.perBatch - 1))
-			}
-		} else {
-			start = (end + 1)
-			end = (start + (dl.perBatch - 1))
-			if totalBatch > 1 && currentBatch == totalBatch {
-				end = (lenList - 1)
-			}
-		}
-
-		size := (end - start) + 1
-		batchJob := make([]*fileToDownload, size)
+// parseTime parse date (UTC midnight), RFC3339 or unix timestamp, empty string is zero time
+func parseTime(v string) (time.Time, error) {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return time.Time{}, nil
+	}
 
-		n := start
-		c := 0
-		for n <= end {
-			batchJob[c] = dl.list[n]
-			n++
-			c++
-		}
+	if ts, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return time.Unix(ts, 0), nil
+	}
 
-		a := actualBatchDownload{
-			files:   batchJob,
-			timeout: dl.dto,
+	for _, layout := range []string{"2006-01-02", time.RFC3339} {
+		if t, err := time.Parse(layout, v); err == nil {
+			return t, nil
 		}
-
-		fmt.Println(fmt.Sprintf("    [PROCESSING %d %s AT ONCE]", dl.perBatch, strings.ToUpper(dl.media)))
-		a.download()
 	}
 
-	return true
-}
-
-type actualBatchDownload struct {
-	timeout int
-	files   []*fileToDownload
-}
-
-type downloadResult struct {
-	timeStart         time.Time
-	processError      error
-	elapsedDuration   float64
-	alreadyDownloaded bool
-	sizeStored        int64
-	sizeDownloaded    int64
-	job               *fileToDownload
+	return time.Time{}, fmt.Errorf("Unable to parse time %s, use 2006-01-02, RFC3339 or unix timestamp", v)
 }
 
-func (d *actualBatchDownload) download() {
-	wg := &sync.WaitGroup{}
-
-	for _, f := range d.files {
-		wg.Add(1)
-
-		go func(ftd *fileToDownload) {
-			resultChan := make(chan downloadResult, 1)
-
-			go func() {
-				startTime := time.Now()
-				result := downloadResult{
-					job:       ftd,
-					timeStart: startTime,
-				}
-
-				if s, mErr := os.Stat(ftd.destFile); os.IsNotExist(mErr) {
-					fmt.Println(color.WhiteString("\t[DOWNLOADING] [%d] [%s]", startTime.Unix(), ftd.url))
-					client := &http.Client{Timeout: (time.Second * time.Duration(d.timeout))}
-					useragent := defaultUserAgents[rand.Intn(len(defaultUserAgents))]
-					request, _ := http.NewRequest("GET", ftd.url, nil)
-					request.Header.Set("User-Agent", useragent)
-					response, requestError := client.Do(request)
-					output, _ := os.Create(ftd.destFile)
-					defer output.Close()
-
-					if requestError != nil {
-						_ = os.Remove(ftd.destFile)
-						result.processError = requestError
-					}
-
-					if response.StatusCode != http.StatusOK {
-						_ = os.Remove(ftd.destFile)
-						result.processError = fmt.Errorf("[%d] [%s]", response.StatusCode, ftd.url)
-					}
-					defer response.Body.Close()
-
-					if _, writeError := io.Copy(output, response.Body); writeError != nil {
-						_ = os.Remove(ftd.destFile)
-						result.processError = writeError
-					}
-
-					result.alreadyDownloaded = false
-					result.sizeDownloaded = response.ContentLength
-					result.sizeStored = response.ContentLength
-				} else {
-					result.alreadyDownloaded = true
-					result.sizeStored = s.Size()
-					fmt.Println(color.WhiteString("\t[DOWNLOADED] [%s]", ftd.destFile))
-				}
-
-				result.elapsedDuration = time.Since(startTime).Seconds()
-				resultChan <- result
-
-				close(resultChan)
-			}()
-
-			select {
-			case r := <-resultChan:
-				if r.processError != nil {
-					// file already deleted if any http/io error occured
-					msg := color.New(color.FgHiRed, color.Bold).SprintfFunc()("\t[ERROR] %s", r.processError.Error())
-					fmt.Println(msg)
-				} else {
-					counterFile++
-					counterStored = (counterStored + r.sizeStored)
-					if !r.alreadyDownloaded {
-						counterSize = (counterSize + r.sizeDownloaded)
-						fmt.Println(color.GreenString("\t[SUCCESS] [%f] [%s]", r.elapsedDuration, r.job.destFile))
-					}
-				}
-				wg.Done()
-			case <-time.After(time.Second * time.Duration(d.timeout)):
-				r := <-resultChan
-				_ = os.Remove(r.job.destFile)
-				msg := color.New(color.FgHiMagenta, color.Bold).
-					SprintfFunc()("\t[ERROR TIMEOUT] [%f] [%s]", r.elapsedDuration, r.job.url)
-				fmt.Println(msg)
-				wg.Done()
-			}
-		}(f)
+// splitList split comma separated flag value, empty item is removed
+func splitList(v string) []string {
+	items := []string{}
+	for _, i := range strings.Split(v, ",") {
+		if i = strings.TrimSpace(i); i != "" {
+			items = append(items, i)
+		}
 	}
 
-	wg.Wait()
+	return items
 }
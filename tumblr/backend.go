@@ -0,0 +1,64 @@
+// Copyright (c) 2016 - Sarjono Mukti Aji <me@simukti.net>
+// Unless otherwise noted, this source code license is MIT-License
+
+package tumblr
+
+import (
+	"encoding/xml"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/url"
+)
+
+// Query single page request of blog posts
+type Query struct {
+	Blog    string // blog username
+	BlogURL string // blog base url, e.g. http://username.tumblr.com
+	Media   string // post type filter
+	Start   int    // offset of first post
+	Num     int    // posts per page, 0 only fetch blog detail and total posts
+	Tag     string // only posts with this tag, empty is every post
+	ID      string // single post, other filters are ignored
+}
+
+// Backend fetch one page of blog posts and map it into Tumblr model,
+// so photo and video extraction works unchanged for every api version.
+type Backend interface {
+	Posts(client *http.Client, q Query) (*Tumblr, error)
+}
+
+// XMLBackend legacy /api/read xml api, it does not need api key
+type XMLBackend struct{}
+
+// Posts implement Backend
+func (XMLBackend) Posts(client *http.Client, q Query) (*Tumblr, error) {
+	api := fmt.Sprintf(APIURL, q.BlogURL, q.Media, q.Num, q.Start)
+	if q.Tag != "" {
+		api += "&tagged=" + url.QueryEscape(q.Tag)
+	}
+	if q.ID != "" {
+		api = fmt.Sprintf(APIPOSTURL, q.BlogURL, url.QueryEscape(q.ID))
+	}
+
+	return getXMLSource(client, api)
+}
+
+func getXMLSource(client *http.Client, api string) (*Tumblr, error) {
+	t := &Tumblr{}
+	useragent := defaultUserAgents[rand.Intn(len(defaultUserAgents))]
+	apiReq, _ := http.NewRequest("GET", api, nil)
+	apiReq.Header.Set("User-Agent", useragent)
+	apiResp, apiErr := client.Do(apiReq)
+	if apiErr != nil {
+		return t, apiErr
+	}
+	defer apiResp.Body.Close()
+
+	if apiResp.StatusCode != http.StatusOK {
+		return t, newStatusError(apiResp, api)
+	}
+	err := xml.NewDecoder(apiResp.Body).Decode(t)
+
+	return t, err
+}
@@ -0,0 +1,99 @@
+// Copyright (c) 2016 - Sarjono Mukti Aji <me@simukti.net>
+// Unless otherwise noted, this source code license is MIT-License
+
+package tumblr
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/fatih/color"
+)
+
+const (
+	// TUMBLRDOMAIN domain of every blog which has no custom domain
+	TUMBLRDOMAIN = ".tumblr.com"
+)
+
+var (
+	blogNameRgx = regexp.MustCompile(`^[a-z0-9-]+$`)
+	dashBlogRgx = regexp.MustCompile(`^/(?:blog/(?:view/)?)?([a-z0-9-]+)(?:/|$)`)
+)
+
+// ParseBlog blog name of bare name or tumblr url (https://name.tumblr.com/, https://www.tumblr.com/name),
+// custom domain is returned as lower case host and resolved into blog name when downloaded
+func ParseBlog(s string) (string, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if blogNameRgx.MatchString(s) {
+		return s, nil
+	}
+
+	raw := s
+	if !strings.Contains(raw, "://") {
+		raw = "http://" + raw
+	}
+	u, err := url.Parse(raw)
+	if err != nil || u.Hostname() == "" {
+		return "", fmt.Errorf("Invalid blog name or url %s", s)
+	}
+
+	host := strings.TrimSuffix(u.Hostname(), ".")
+	switch {
+	case host == "tumblr.com" || host == "www.tumblr.com":
+		if m := dashBlogRgx.FindStringSubmatch(u.Path); len(m) == 2 {
+			return m[1], nil
+		}
+	case strings.HasSuffix(host, TUMBLRDOMAIN):
+		if name := strings.TrimSuffix(host, TUMBLRDOMAIN); blogNameRgx.MatchString(name) {
+			return name, nil
+		}
+	case strings.Contains(host, "."):
+		return host, nil
+	}
+
+	return "", fmt.Errorf("Invalid blog name or url %s", s)
+}
+
+// isDomain check if parsed blog is custom domain instead of blog name
+func isDomain(blog string) bool {
+	return strings.Contains(blog, ".")
+}
+
+// blogIdentifier api v2 blog identifier of blog name or custom domain
+func blogIdentifier(blog string) string {
+	if isDomain(blog) {
+		return blog
+	}
+
+	return blog + TUMBLRDOMAIN
+}
+
+// resolveDomain replace custom domain of job with its blog name,
+// so the same blog is always stored in the folder of its canonical name
+func (job *tumblrJob) resolveDomain() error {
+	domain := job.username
+	client := job.httpClient(job.connectTimeout)
+	query := Query{
+		Blog:    domain,
+		BlogURL: job.blogURL(domain),
+	}
+	var blog *Tumblr
+	err := job.pageRetry.do(job.out, "BLOG "+domain, func() (err error) {
+		blog, err = job.backend.Posts(client, query)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("Unable to resolve %s [%s]", domain, err.Error())
+	}
+
+	name, err := ParseBlog(blog.TumbleBlog.Name)
+	if err != nil || isDomain(name) {
+		return fmt.Errorf("Unable to resolve blog name of %s", domain)
+	}
+	job.username = name
+	fmt.Fprintln(job.out, color.GreenString("[INFO] RESOLVED %s TO: %s", domain, name))
+
+	return nil
+}
@@ -0,0 +1,167 @@
+// Copyright (c) 2016 - Sarjono Mukti Aji <me@simukti.net>
+// Unless otherwise noted, this source code license is MIT-License
+
+package tumblr
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+// documentMedia media which post itself is archived as html document, instead of downloaded file
+var documentMedia = map[string]bool{TEXT: true, QUOTE: true, LINK: true, CHAT: true, ANSWER: true, AUDIO: true}
+
+// post body is html from tumblr, it is kept as is
+var documentTemplate = template.Must(template.New("post").Funcs(template.FuncMap{
+	"html": func(s string) template.HTML { return template.HTML(s) },
+	"date": func(ts int) string { return time.Unix(int64(ts), 0).UTC().Format(time.RFC3339) },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Blog}} - {{.Post.ID}}</title>
+</head>
+<body>
+<article class="{{.Media}}" id="{{.Post.ID}}">
+<header><time datetime="{{date .Post.Timestamp}}">{{date .Post.Timestamp}}</time>{{with .Post.URL}} <a href="{{.}}">{{.}}</a>{{end}}</header>
+{{- with .Post}}
+{{- if .RebloggedFromName}}
+<p class="reblog">reblogged from <a href="{{.RebloggedFromURL}}">{{.RebloggedFromName}}</a>
+{{- if and .RebloggedRootName (ne .RebloggedRootName .RebloggedFromName)}}, originally <a href="{{.RebloggedRootURL}}">{{.RebloggedRootName}}</a>{{end}}</p>{{end}}
+{{- if .RegularTitle}}
+<h1>{{.RegularTitle}}</h1>{{end}}
+{{- if .RegularBody}}
+<section class="body">{{html .RegularBody}}</section>{{end}}
+{{- if .QuoteText}}
+<blockquote>{{html .QuoteText}}</blockquote>{{end}}
+{{- if .QuoteSource}}
+<p class="source">{{html .QuoteSource}}</p>{{end}}
+{{- if .LinkURL}}
+<h1><a href="{{.LinkURL}}">{{if .LinkText}}{{html .LinkText}}{{else}}{{.LinkURL}}{{end}}</a></h1>{{end}}
+{{- if .LinkDescription}}
+<section class="description">{{html .LinkDescription}}</section>{{end}}
+{{- if .ConversationTitle}}
+<h1>{{.ConversationTitle}}</h1>{{end}}
+{{- if .Conversation}}
+<dl class="chat">{{range .Conversation}}
+<dt>{{.Label}}</dt><dd>{{.Text}}</dd>{{end}}
+</dl>{{else if .ConversationText}}
+<pre class="chat">{{.ConversationText}}</pre>{{end}}
+{{- if .Question}}
+<section class="question">{{html .Question}}</section>{{end}}
+{{- if .Answer}}
+<section class="answer">{{html .Answer}}</section>{{end}}
+{{- if or .ID3Artist .ID3Title .ID3Album}}
+<p class="audio">{{.ID3Artist}}{{if .ID3Title}} - {{.ID3Title}}{{end}}{{if .ID3Album}} ({{.ID3Album}}){{end}}</p>{{end}}
+{{- if .AudioPlayer}}
+<section class="player">{{html .AudioPlayer}}</section>{{end}}
+{{- if .AudioCaption}}
+<section class="caption">{{html .AudioCaption}}</section>{{end}}
+{{- if .Tags}}
+<footer>{{range .Tags}}<a rel="tag">#{{.}}</a> {{end}}</footer>{{end}}
+{{- end}}
+</article>
+</body>
+</html>
+`))
+
+// processDocument write post as <id>_<timestamp>.html with its json metadata next to it,
+// it is skipped if already recorded in manifest. It return false if document can not be written.
+func (job *tumblrJob) processDocument(t *Tumblr, p *Post) bool {
+	// post url contain slug which may change, post id never does,
+	// canonical blog url is used even if BaseURL option is set
+	key := fmt.Sprintf(BASEURL, job.username) + "/post/" + p.ID
+	dest, err := t.postFile(job, p, ".html")
+	if err != nil {
+		job.downloader.addFailedFile()
+		msg := color.New(color.FgHiRed, color.Bold).SprintfFunc()("\t[ERROR] [%s] %s", p.ID, err.Error())
+		fmt.Fprintln(job.out, msg)
+		return false
+	}
+	if job.dryRun {
+		job.plan(p.ID, key, dest)
+		return true
+	}
+
+	if e, ok := job.manifest.Lookup(key); ok && e.intact(job.mainFolder) {
+		job.downloader.addFile(e.Size, 0)
+		fmt.Fprintln(job.out, color.WhiteString("\t[DOWNLOADED] [%s]", e.Path(job.mainFolder)))
+		return true
+	} else if ok && e.Status == STATUSDONE {
+		fmt.Fprintln(job.out, color.YellowString("\t[MISSING OR CHANGED] [%s]", e.Path(job.mainFolder)))
+	}
+
+	startTime := time.Now()
+	rel := dest
+	if r, err := filepath.Rel(job.mainFolder, dest); err == nil {
+		rel = r
+	}
+	pm := &PostMetadata{Blog: t.TumbleBlog.Name, Media: job.media, Post: *p, Files: []string{rel}}
+
+	e := ManifestEntry{
+		PostID:  p.ID,
+		Media:   job.media,
+		URL:     key,
+		Dest:    rel,
+		Status:  STATUSDONE,
+		Started: startTime,
+	}
+
+	err = writeDocument(dest, pm)
+	if err == nil {
+		// document always has its metadata, json lines file is written too if enabled
+		err = job.meta.writeSidecar(pm, strings.TrimSuffix(dest, ".html")+".json", true)
+	}
+	if err == nil && job.meta.mode == METAJSONL {
+		err = job.meta.write(pm, "", true)
+	}
+	if err == nil {
+		e.Hash, e.Size, err = fileHash(dest)
+	}
+	e.Finished = time.Now()
+
+	if err != nil {
+		e.Status = STATUSFAILED
+		e.Error = err.Error()
+		job.downloader.addFailedFile()
+		msg := color.New(color.FgHiRed, color.Bold).SprintfFunc()("\t[ERROR] [%s] %s", p.ID, err.Error())
+		fmt.Fprintln(job.out, msg)
+	} else {
+		job.downloader.addFile(e.Size, e.Size)
+		fmt.Fprintln(job.out, color.GreenString("\t[SUCCESS] [%f] [%s]", e.Finished.Sub(startTime).Seconds(), dest))
+	}
+
+	if rErr := job.manifest.Record(e); rErr != nil {
+		msg := color.New(color.FgHiRed, color.Bold).SprintfFunc()("\t[ERROR MANIFEST] %s", rErr.Error())
+		fmt.Fprintln(job.out, msg)
+	}
+
+	return err == nil
+}
+
+// writeDocument render html document into temporary file then rename it into dest
+func writeDocument(dest string, pm *PostMetadata) error {
+	buf := &bytes.Buffer{}
+	if err := documentTemplate.Execute(buf, pm); err != nil {
+		return err
+	}
+
+	// layout may put file in folder which does not exists yet
+	if err := os.MkdirAll(filepath.Dir(dest), 0700); err != nil {
+		return err
+	}
+	tmp := dest + PARTSUFFIX
+	if err := ioutil.WriteFile(tmp, buf.Bytes(), 0600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, dest)
+}
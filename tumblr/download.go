@@ -0,0 +1,383 @@
+// Copyright (c) 2016 - Sarjono Mukti Aji <me@simukti.net>
+// Unless otherwise noted, this source code license is MIT-License
+
+package tumblr
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math/rand"
+	"mime"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+type fileToDownload struct {
+	url       string
+	destFile  string
+	postID    string
+	media     string
+	page      int
+	tracker   *pageTracker
+	validator string // ETag or Last-Modified of partially downloaded file
+	post      *postRecord
+	postIndex int
+	// higher resolution urls tried in order before url, next one is tried on 403 or 404
+	candidates []string
+	err        error // destination can not be used, file is refused
+}
+
+// downloadQueue keep n downloads in flight across page and media boundaries,
+// page fetching run ahead of downloads until the bounded queue is full
+type downloadQueue struct {
+	job   *tumblrJob
+	files chan *fileToDownload
+	wg    sync.WaitGroup
+}
+
+type downloadResult struct {
+	timeStart         time.Time
+	processError      error
+	elapsedDuration   float64
+	alreadyDownloaded bool
+	sizeStored        int64
+	sizeDownloaded    int64
+	hash              string
+	job               *fileToDownload
+}
+
+// newDownloadQueue start job.batch download workers
+func newDownloadQueue(job *tumblrJob) *downloadQueue {
+	q := &downloadQueue{
+		job:   job,
+		files: make(chan *fileToDownload, job.queueSize),
+	}
+
+	fmt.Fprintln(job.out, fmt.Sprintf("    [PROCESSING %d FILES AT ONCE]", job.batch))
+	for i := 0; i < job.batch; i++ {
+		q.wg.Add(1)
+		go q.work()
+	}
+
+	return q
+}
+
+// add queue single file, it block while queue is full
+func (q *downloadQueue) add(f *fileToDownload) {
+	q.files <- f
+}
+
+// wait close queue and wait until every queued file is processed
+func (q *downloadQueue) wait() {
+	close(q.files)
+	q.wg.Wait()
+}
+
+func (q *downloadQueue) work() {
+	defer q.wg.Done()
+	out := q.job.out
+
+	for f := range q.files {
+		r := q.download(f)
+		q.record(r)
+
+		if r.processError != nil {
+			q.job.downloader.addFailedFile()
+			// partial download is kept as .part file
+			if ne, ok := r.processError.(net.Error); ok && ne.Timeout() {
+				msg := color.New(color.FgHiMagenta, color.Bold).
+					SprintfFunc()("\t[ERROR TIMEOUT] [%f] [%s]", r.elapsedDuration, r.job.url)
+				fmt.Fprintln(out, msg)
+			} else {
+				msg := color.New(color.FgHiRed, color.Bold).SprintfFunc()("\t[ERROR] %s", r.processError.Error())
+				fmt.Fprintln(out, msg)
+			}
+		} else {
+			if r.alreadyDownloaded {
+				q.job.downloader.addFile(r.sizeStored, 0)
+			} else {
+				q.job.downloader.addFile(r.sizeStored, r.sizeDownloaded)
+				fmt.Fprintln(out, color.GreenString("\t[SUCCESS] [%f] [%s]", r.elapsedDuration, r.job.destFile))
+			}
+		}
+
+		if f.post != nil {
+			if r.processError != nil {
+				f.post.finish(f.postIndex, "", false)
+			} else {
+				f.post.finish(f.postIndex, f.destFile, !r.alreadyDownloaded)
+			}
+		}
+
+		if f.tracker != nil {
+			if r.processError != nil {
+				f.tracker.fail(f.page)
+			}
+			f.tracker.finish(f.page)
+		}
+	}
+}
+
+// download single file, whole request is limited by download timeout
+func (q *downloadQueue) download(ftd *fileToDownload) downloadResult {
+	out := q.job.out
+	startTime := time.Now()
+	result := downloadResult{
+		job:       ftd,
+		timeStart: startTime,
+	}
+
+	// file exists without manifest record is only trusted when blog has no manifest yet,
+	// and it really is a media file not an error page saved by older version
+	if s, mErr := os.Stat(ftd.destFile); mErr == nil && q.job.manifest.fresh &&
+		s.Size() > 0 && allowedContentType(sniffFile(ftd.destFile)) {
+		result.alreadyDownloaded = true
+		result.sizeStored = s.Size()
+		result.hash, _, result.processError = fileHash(ftd.destFile)
+		fmt.Fprintln(out, color.WhiteString("\t[DOWNLOADED] [%s]", ftd.destFile))
+	} else {
+		fmt.Fprintln(out, color.WhiteString("\t[DOWNLOADING] [%d] [%s]", startTime.Unix(), ftd.url))
+		for _, src := range append(ftd.candidates, ftd.url) {
+			result.processError = q.job.mediaRetry.do(out, src, func() error {
+				written, err := q.fetch(ftd, src)
+				result.sizeDownloaded += written
+
+				return err
+			})
+
+			se, ok := result.processError.(*StatusError)
+			if ok && src != ftd.url &&
+				(se.StatusCode == http.StatusForbidden || se.StatusCode == http.StatusNotFound) {
+				fmt.Fprintln(out, color.YellowString("\t[FALLBACK] [%d] [%s]", se.StatusCode, src))
+				continue
+			}
+			break
+		}
+
+		if result.processError == nil {
+			result.hash, result.sizeStored, result.processError = fileHash(ftd.destFile)
+		}
+	}
+
+	result.elapsedDuration = time.Since(startTime).Seconds()
+
+	return result
+}
+
+// fetch single download attempt of src into .part file, which is resumed with Range request
+// on next attempt and renamed into destination once its full length is verified
+func (q *downloadQueue) fetch(ftd *fileToDownload, src string) (int64, error) {
+	part := ftd.destFile + PARTSUFFIX
+	offset := int64(0)
+	if s, err := os.Stat(part); err == nil && ftd.validator != "" {
+		offset = s.Size()
+	}
+
+	client := q.job.httpClient(q.job.downloadTimeout)
+	useragent := defaultUserAgents[rand.Intn(len(defaultUserAgents))]
+	request, _ := http.NewRequest("GET", q.job.mediaURL(src), nil)
+	request.Header.Set("User-Agent", useragent)
+	if offset > 0 {
+		request.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		request.Header.Set("If-Range", ftd.validator)
+	}
+	response, requestError := client.Do(request)
+	if requestError != nil {
+		return 0, requestError
+	}
+	defer response.Body.Close()
+
+	total := response.ContentLength
+	flag := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	switch response.StatusCode {
+	case http.StatusOK:
+		// server ignore range or file changed, start over
+		offset = 0
+	case http.StatusPartialContent:
+		start, size, ok := contentRange(response.Header.Get("Content-Range"))
+		if !ok || start != offset {
+			_ = os.Remove(part)
+			ftd.validator = ""
+			return 0, io.ErrUnexpectedEOF
+		}
+		total = size
+		flag = os.O_WRONLY | os.O_APPEND
+	case http.StatusRequestedRangeNotSatisfiable:
+		// part file is bigger than remote file
+		_ = os.Remove(part)
+		ftd.validator = ""
+		return 0, io.ErrUnexpectedEOF
+	default:
+		return 0, newStatusError(response, src)
+	}
+
+	// refuse html error page or anything else which is not media before it touch the disk,
+	// resumed part was already checked on its first attempt
+	body := io.Reader(response.Body)
+	if offset == 0 {
+		head := make([]byte, 512)
+		n, readError := io.ReadFull(response.Body, head)
+		if readError == io.EOF {
+			return 0, fmt.Errorf("[EMPTY] [%s]", src)
+		}
+		if readError != nil && readError != io.ErrUnexpectedEOF {
+			return 0, readError
+		}
+		if ct := mediaContentType(response.Header.Get("Content-Type"), head[:n]); !allowedContentType(ct) {
+			return 0, fmt.Errorf("[%s] [NOT MEDIA] [%s]", ct, src)
+		}
+		body = io.MultiReader(bytes.NewReader(head[:n]), response.Body)
+	}
+
+	// only strong validator is allowed in If-Range
+	ftd.validator = response.Header.Get("Last-Modified")
+	if etag := response.Header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		ftd.validator = etag
+	}
+	// validator is kept before body is written, killed run can still resume .part file
+	if offset == 0 && ftd.validator != "" {
+		q.recordPartial(ftd)
+	}
+
+	// layout may put file in folder which does not exists yet
+	if err := os.MkdirAll(filepath.Dir(part), 0700); err != nil {
+		return 0, err
+	}
+	output, createError := os.OpenFile(part, flag, 0600)
+	if createError != nil {
+		return 0, createError
+	}
+
+	written, writeError := io.Copy(output, body)
+	if closeError := output.Close(); writeError == nil {
+		writeError = closeError
+	}
+	if writeError != nil {
+		// keep .part file to be resumed
+		return written, writeError
+	}
+
+	if total >= 0 && offset+written != total {
+		return written, io.ErrUnexpectedEOF
+	}
+
+	return written, os.Rename(part, ftd.destFile)
+}
+
+// mediaContentType content type from header, sniffed from first bytes if header is missing or generic
+func mediaContentType(header string, head []byte) string {
+	ct, _, err := mime.ParseMediaType(header)
+	if err != nil || ct == "" || ct == "application/octet-stream" || ct == "binary/octet-stream" {
+		ct, _, _ = mime.ParseMediaType(http.DetectContentType(head))
+	}
+
+	return ct
+}
+
+// allowedContentType only image, video and audio body is kept
+func allowedContentType(ct string) bool {
+	for _, prefix := range allowedContentTypes {
+		if strings.HasPrefix(ct, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// sniffFile content type of existing file detected from its first bytes
+func sniffFile(path string) string {
+	f, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	head := make([]byte, 512)
+	n, _ := io.ReadFull(f, head)
+
+	return mediaContentType("", head[:n])
+}
+
+// contentRange parse "bytes start-end/size" header value, size is -1 if unknown
+func contentRange(v string) (int64, int64, bool) {
+	var start, end int64
+	var size string
+	if _, err := fmt.Sscanf(v, "bytes %d-%d/%s", &start, &end, &size); err != nil {
+		return 0, 0, false
+	}
+
+	if size == "*" {
+		return start, -1, true
+	}
+	total, err := strconv.ParseInt(size, 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+
+	return start, total, true
+}
+
+// record write download result into blog manifest
+func (q *downloadQueue) record(r downloadResult) {
+	e := ManifestEntry{
+		PostID:   r.job.postID,
+		Media:    r.job.media,
+		URL:      r.job.url,
+		Dest:     r.job.destFile,
+		Size:     r.sizeStored,
+		Hash:     r.hash,
+		Status:   STATUSDONE,
+		Started:  r.timeStart,
+		Finished: r.timeStart.Add(time.Duration(r.elapsedDuration * float64(time.Second))),
+	}
+
+	if rel, err := filepath.Rel(q.job.mainFolder, r.job.destFile); err == nil {
+		e.Dest = rel
+	}
+
+	if r.processError != nil {
+		e.Status = STATUSFAILED
+		e.Size = 0
+		e.Hash = ""
+		e.Error = r.processError.Error()
+		e.Validator = r.job.validator
+	}
+
+	q.writeRecord(e)
+}
+
+// recordPartial write validator of .part file into blog manifest as soon as response headers arrive
+func (q *downloadQueue) recordPartial(ftd *fileToDownload) {
+	e := ManifestEntry{
+		PostID:    ftd.postID,
+		Media:     ftd.media,
+		URL:       ftd.url,
+		Dest:      ftd.destFile,
+		Status:    STATUSPARTIAL,
+		Validator: ftd.validator,
+		Started:   time.Now(),
+	}
+
+	if rel, err := filepath.Rel(q.job.mainFolder, ftd.destFile); err == nil {
+		e.Dest = rel
+	}
+
+	q.writeRecord(e)
+}
+
+func (q *downloadQueue) writeRecord(e ManifestEntry) {
+	if err := q.job.manifest.Record(e); err != nil {
+		msg := color.New(color.FgHiRed, color.Bold).SprintfFunc()("\t[ERROR MANIFEST] %s", err.Error())
+		fmt.Fprintln(q.job.out, msg)
+	}
+}
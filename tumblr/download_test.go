@@ -0,0 +1,289 @@
+// Copyright (c) 2016 - Sarjono Mukti Aji <me@simukti.net>
+// Unless otherwise noted, this source code license is MIT-License
+
+package tumblr
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// truncated answer with full Content-Length but only first n bytes of body, then drop connection
+func truncated(n int) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body := mediaBody(r.URL.Path)
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Header().Set("Content-Length", fmt.Sprint(len(body)))
+		w.Header().Set("Last-Modified", fakeModTime.UTC().Format(http.TimeFormat))
+		w.WriteHeader(http.StatusOK)
+		w.Write(body[:n])
+		w.(http.Flusher).Flush()
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err == nil {
+			conn.Close()
+		}
+	}
+}
+
+// failOnce answer first request with given status, then serve default media file
+func failOnce(status int) http.HandlerFunc {
+	failed := false
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !failed {
+			failed = true
+			w.WriteHeader(status)
+			return
+		}
+		serveMedia(w, r)
+	}
+}
+
+func TestDownloadNotFound(t *testing.T) {
+	f := newFakeTumblr()
+	defer f.Close()
+	f.addPosts("photo", photoPosts(1000, 1500000000, 2)...)
+	f.handleMedia("/p/tumblr_1000_1280.jpg", http.NotFound)
+	dest, clean := tempDest(t)
+	defer clean()
+
+	stats, out := f.download(t, dest, Media(PHOTO))
+
+	// 404 is permanent, it is not retried
+	if n := f.hitCount("/p/tumblr_1000_1280.jpg"); n != 1 {
+		t.Errorf("requests = %d, want 1", n)
+	}
+	if stats.Files != 1 || stats.FailedFile != 1 || !strings.Contains(out, "[404]") {
+		t.Errorf("stats = %+v\n%s", stats, out)
+	}
+	mustNotExist(t, photoFile(dest, 1000, 1500000000))
+	mustNotExist(t, photoFile(dest, 1000, 1500000000)+PARTSUFFIX)
+	mustExist(t, photoFile(dest, 999, 1500000000-86400))
+
+	e, ok := readManifest(t, dest).Lookup(FAKEMEDIAHOST + "/p/tumblr_1000_1280.jpg")
+	if !ok || e.Status != STATUSFAILED || e.Error == "" {
+		t.Errorf("manifest entry = %+v, %v", e, ok)
+	}
+}
+
+func TestDownloadNotMedia(t *testing.T) {
+	f := newFakeTumblr()
+	defer f.Close()
+	f.addPosts("photo", photoPosts(1000, 1500000000, 1)...)
+	f.handleMedia("/p/tumblr_1000_1280.jpg", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, "<html><body>rate limited</body></html>")
+	})
+	dest, clean := tempDest(t)
+	defer clean()
+
+	stats, out := f.download(t, dest, Media(PHOTO))
+
+	if stats.FailedFile != 1 || !strings.Contains(out, "[NOT MEDIA]") {
+		t.Errorf("stats = %+v\n%s", stats, out)
+	}
+	mustNotExist(t, photoFile(dest, 1000, 1500000000))
+	mustNotExist(t, photoFile(dest, 1000, 1500000000)+PARTSUFFIX)
+}
+
+func TestDownloadEmptyBody(t *testing.T) {
+	f := newFakeTumblr()
+	defer f.Close()
+	f.addPosts("photo", photoPosts(1000, 1500000000, 1)...)
+	f.handleMedia("/p/tumblr_1000_1280.jpg", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/jpeg")
+	})
+	dest, clean := tempDest(t)
+	defer clean()
+
+	stats, out := f.download(t, dest, Media(PHOTO))
+
+	if stats.FailedFile != 1 || !strings.Contains(out, "[EMPTY]") {
+		t.Errorf("stats = %+v\n%s", stats, out)
+	}
+	mustNotExist(t, photoFile(dest, 1000, 1500000000))
+}
+
+func TestDownloadTruncatedBodyResumed(t *testing.T) {
+	f := newFakeTumblr()
+	defer f.Close()
+	f.addPosts("photo", photoPosts(1000, 1500000000, 1)...)
+	f.handleMedia("/p/tumblr_1000_1280.jpg", truncated(500))
+	dest, clean := tempDest(t)
+	defer clean()
+
+	file := photoFile(dest, 1000, 1500000000)
+	stats, _ := f.download(t, dest, Media(PHOTO), MediaRetry(RetryPolicy{Attempts: 1}))
+	if stats.FailedFile != 1 {
+		t.Fatalf("stats = %+v", stats)
+	}
+	mustNotExist(t, file)
+	if part, err := ioutil.ReadFile(file + PARTSUFFIX); err != nil || len(part) != 500 {
+		t.Fatalf("part file = %d bytes, %v", len(part), err)
+	}
+
+	// next run continue from end of .part file
+	f.handleMedia("/p/tumblr_1000_1280.jpg", serveMedia)
+	stats, _ = f.download(t, dest, Media(PHOTO))
+	if stats.FailedFile != 0 || stats.Downloaded != int64(len(mediaBody("/p/tumblr_1000_1280.jpg"))-500) {
+		t.Errorf("stats = %+v", stats)
+	}
+	if len(f.ranges) != 1 || f.ranges[0] != "bytes=500-" {
+		t.Errorf("ranges = %v, want [bytes=500-]", f.ranges)
+	}
+	got, err := ioutil.ReadFile(file)
+	if err != nil || !bytes.Equal(got, mediaBody("/p/tumblr_1000_1280.jpg")) {
+		t.Errorf("resumed file = %d bytes, %v", len(got), err)
+	}
+	mustNotExist(t, file+PARTSUFFIX)
+}
+
+func TestDownloadKilledRunResumed(t *testing.T) {
+	f := newFakeTumblr()
+	defer f.Close()
+	f.addPosts("photo", photoPosts(1000, 1500000000, 1)...)
+	f.handleMedia("/p/tumblr_1000_1280.jpg", truncated(500))
+	dest, clean := tempDest(t)
+	defer clean()
+
+	file := photoFile(dest, 1000, 1500000000)
+	f.download(t, dest, Media(PHOTO), MediaRetry(RetryPolicy{Attempts: 1}))
+
+	// killed run never write its failed record, only the partial one written on response headers
+	path := filepath.Join(dest, FAKEBLOG, MANIFESTFILE)
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kept := []string{}
+	for _, line := range strings.SplitAfter(string(b), "\n") {
+		if !strings.Contains(line, `"status":"`+STATUSFAILED+`"`) {
+			kept = append(kept, line)
+		}
+	}
+	if err := ioutil.WriteFile(path, []byte(strings.Join(kept, "")), 0600); err != nil {
+		t.Fatal(err)
+	}
+	e, ok := readManifest(t, dest).Lookup(FAKEMEDIAHOST + "/p/tumblr_1000_1280.jpg")
+	if !ok || e.Status != STATUSPARTIAL || e.Validator == "" {
+		t.Fatalf("manifest entry = %+v, %v", e, ok)
+	}
+
+	f.handleMedia("/p/tumblr_1000_1280.jpg", serveMedia)
+	stats, _ := f.download(t, dest, Media(PHOTO))
+	if stats.FailedFile != 0 || stats.Downloaded != int64(len(mediaBody("/p/tumblr_1000_1280.jpg"))-500) {
+		t.Errorf("stats = %+v", stats)
+	}
+	if len(f.ranges) != 1 || f.ranges[0] != "bytes=500-" {
+		t.Errorf("ranges = %v, want [bytes=500-]", f.ranges)
+	}
+	mustExist(t, file)
+}
+
+func TestDownloadTruncatedBodyRetried(t *testing.T) {
+	f := newFakeTumblr()
+	defer f.Close()
+	f.addPosts("photo", photoPosts(1000, 1500000000, 1)...)
+	cut := truncated(300)
+	calls := 0
+	f.handleMedia("/p/tumblr_1000_1280.jpg", func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			cut(w, r)
+			return
+		}
+		serveMedia(w, r)
+	})
+	dest, clean := tempDest(t)
+	defer clean()
+
+	stats, out := f.download(t, dest, Media(PHOTO))
+
+	if stats.FailedFile != 0 || stats.Files != 1 || !strings.Contains(out, "[RETRY 1/1]") {
+		t.Errorf("stats = %+v\n%s", stats, out)
+	}
+	if len(f.ranges) != 1 || f.ranges[0] != "bytes=300-" {
+		t.Errorf("ranges = %v, want [bytes=300-]", f.ranges)
+	}
+	mustExist(t, photoFile(dest, 1000, 1500000000))
+}
+
+func TestDownloadTemporaryErrorRetried(t *testing.T) {
+	f := newFakeTumblr()
+	defer f.Close()
+	f.addPosts("photo", photoPosts(1000, 1500000000, 1)...)
+	f.handleMedia("/p/tumblr_1000_1280.jpg", failOnce(http.StatusServiceUnavailable))
+	dest, clean := tempDest(t)
+	defer clean()
+
+	stats, _ := f.download(t, dest, Media(PHOTO))
+
+	if n := f.hitCount("/p/tumblr_1000_1280.jpg"); n != 2 {
+		t.Errorf("requests = %d, want 2", n)
+	}
+	if stats.FailedFile != 0 || stats.Files != 1 {
+		t.Errorf("stats = %+v", stats)
+	}
+}
+
+func TestRetryable(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{&StatusError{StatusCode: http.StatusNotFound}, false},
+		{&StatusError{StatusCode: http.StatusForbidden}, false},
+		{&StatusError{StatusCode: http.StatusTooManyRequests}, true},
+		{&StatusError{StatusCode: http.StatusServiceUnavailable}, true},
+		{errors.New("[NOT MEDIA]"), false},
+	}
+
+	for _, tt := range tests {
+		if got := retryable(tt.err); got != tt.want {
+			t.Errorf("retryable(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	p := RetryPolicy{Attempts: 5, Backoff: time.Second, MaxBackoff: 3 * time.Second}
+
+	for attempt, want := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 3: 3 * time.Second} {
+		if got := p.delay(attempt, errors.New("x")); got != want {
+			t.Errorf("delay(%d) = %s, want %s", attempt, got, want)
+		}
+	}
+
+	// zero backoff never wait, overflowed shift is clamped
+	if got := (RetryPolicy{Attempts: 3, MaxBackoff: time.Minute}).delay(2, errors.New("x")); got != 0 {
+		t.Errorf("delay without backoff = %s, want 0s", got)
+	}
+	if got := p.delay(70, errors.New("x")); got != 3*time.Second {
+		t.Errorf("delay(70) = %s, want 3s", got)
+	}
+
+	// Retry-After win over shorter backoff
+	if got := p.delay(1, &StatusError{StatusCode: 429, RetryAfter: 10 * time.Second}); got != 10*time.Second {
+		t.Errorf("delay with Retry-After = %s, want 10s", got)
+	}
+
+	p.Jitter = 0.5
+	for i := 0; i < 20; i++ {
+		if got := p.delay(2, errors.New("x")); got < time.Second || got > 3*time.Second {
+			t.Errorf("delay with jitter = %s, want 1s - 3s", got)
+		}
+	}
+}
+
+func TestNewStatusErrorRetryAfter(t *testing.T) {
+	resp := &http.Response{StatusCode: 429, Header: http.Header{"Retry-After": {"7"}}}
+	if e := newStatusError(resp, "u"); e.RetryAfter != 7*time.Second || !e.Temporary() {
+		t.Errorf("status error = %+v", e)
+	}
+}
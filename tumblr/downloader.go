@@ -0,0 +1,599 @@
+// Copyright (c) 2016 - Sarjono Mukti Aji <me@simukti.net>
+// Unless otherwise noted, this source code license is MIT-License
+
+package tumblr
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Option set single Downloader setting
+type Option func(*config)
+
+// config all settings used by a download job
+type config struct {
+	dest            string
+	media           string
+	batch           int
+	connectTimeout  int
+	downloadTimeout int
+	perPage         int
+	limitPage       int
+	out             io.Writer
+	backend         Backend
+	baseURL         string
+	mediaHost       string
+	client          *http.Client
+	sinceLast       bool
+	queueSize       int
+	pageRetry       RetryPolicy
+	mediaRetry      RetryPolicy
+	metadata        string
+	photoSize       string
+	photoWidths     []int // parsed photoSize
+	photoOriginal   bool
+	embedCommand    string
+	layout          string
+	after           time.Time
+	before          time.Time
+	tags            []string
+	excludeTags     []string
+	reblogs         string
+	postIDs         []string
+	dryRun          bool
+	planOut         io.Writer
+}
+
+// Stats accumulated result of all jobs processed by a Downloader
+type Stats struct {
+	Posts       int   // processed posts
+	Files       int   // downloaded and already downloaded files
+	Downloaded  int64 // bytes downloaded on this run
+	Stored      int64 // bytes stored in destination folder
+	FailedPage  int   // api pages (or single posts) which could not be fetched
+	FailedFile  int   // files which could not be downloaded or written
+	FailedEmbed int   // EmbedCommand calls which failed
+	Planned     int   // files which would be downloaded, only counted on dry run
+}
+
+// Downloader download tumblr blog media, it is safe to run several jobs at once
+type Downloader struct {
+	cfg   config
+	mu    sync.Mutex
+	stats Stats
+}
+
+// Destination set main destination folder, it must already exists
+func Destination(dir string) Option {
+	return func(c *config) {
+		c.dest = dir
+	}
+}
+
+// Media set media type to download, "all" or comma separated PHOTO, VIDEO, TEXT, QUOTE, LINK, CHAT, ANSWER, AUDIO
+func Media(media string) Option {
+	return func(c *config) {
+		c.media = media
+	}
+}
+
+// Batch set files download at once, every worker start next file as soon as it is finished
+func Batch(n int) Option {
+	return func(c *config) {
+		c.batch = n
+	}
+}
+
+// QueueSize set max files waiting for download worker, page fetching wait while it is full
+func QueueSize(n int) Option {
+	return func(c *config) {
+		c.queueSize = n
+	}
+}
+
+// PageRetry set retry policy of api page request
+func PageRetry(p RetryPolicy) Option {
+	return func(c *config) {
+		c.pageRetry = p
+	}
+}
+
+// MediaRetry set retry policy of media file request
+func MediaRetry(p RetryPolicy) Option {
+	return func(c *config) {
+		c.mediaRetry = p
+	}
+}
+
+// ConnectTimeout set api request timeout in seconds
+func ConnectTimeout(seconds int) Option {
+	return func(c *config) {
+		c.connectTimeout = seconds
+	}
+}
+
+// DownloadTimeout set single file download timeout in seconds
+func DownloadTimeout(seconds int) Option {
+	return func(c *config) {
+		c.downloadTimeout = seconds
+	}
+}
+
+// PerPage set posts per page request
+func PerPage(n int) Option {
+	return func(c *config) {
+		c.perPage = n
+	}
+}
+
+// LimitPage set max page to fetch, 0 is unlimited (all page)
+func LimitPage(n int) Option {
+	return func(c *config) {
+		c.limitPage = n
+	}
+}
+
+// Output set writer for progress messages, default is os.Stdout
+func Output(w io.Writer) Option {
+	return func(c *config) {
+		c.out = w
+	}
+}
+
+// API set backend used to fetch blog posts, default is XMLBackend
+func API(b Backend) Option {
+	return func(c *config) {
+		c.backend = b
+	}
+}
+
+// BaseURL set blog url format, username is given as its only verb, default is BASEURL
+func BaseURL(format string) Option {
+	return func(c *config) {
+		c.baseURL = format
+	}
+}
+
+// MediaHost rewrite scheme and host of every media url into given base url,
+// e.g. http://127.0.0.1:8080 to download from local stand-in server
+func MediaHost(base string) Option {
+	return func(c *config) {
+		c.mediaHost = base
+	}
+}
+
+// HTTPClient set client used for every request, its transport, cookie jar and redirect policy
+// are kept but timeout is replaced by ConnectTimeout or DownloadTimeout
+func HTTPClient(client *http.Client) Option {
+	return func(c *config) {
+		c.client = client
+	}
+}
+
+// SinceLast stop paging as soon as posts already seen on last finished run are reached
+func SinceLast(enable bool) Option {
+	return func(c *config) {
+		c.sinceLast = enable
+	}
+}
+
+// Metadata set post metadata output, METANONE, METAPOST (json sidecar per post)
+// or METAJSONL (one json lines file per blog)
+func Metadata(mode string) Option {
+	return func(c *config) {
+		c.metadata = mode
+	}
+}
+
+// PhotoSize set which photo variant is downloaded: PHOTOLARGEST, max width (e.g. "1280")
+// or comma separated widths tried in order (e.g. "1280,500,400") which fall back to largest one
+func PhotoSize(policy string) Option {
+	return func(c *config) {
+		c.photoSize = policy
+	}
+}
+
+// PhotoOriginal try original and higher resolution url of tumblr hosted photo first,
+// chosen photo url is downloaded if they respond with 403 or 404
+func PhotoOriginal(enable bool) Option {
+	return func(c *config) {
+		c.photoOriginal = enable
+	}
+}
+
+// EmbedCommand set command called with url of every new embedded video (youtube, vimeo, instagram, ...)
+// as its last argument, e.g. "youtube-dl --no-progress", it run inside folder which
+// layout give to files of the post, failed call is counted in Stats.FailedEmbed
+func EmbedCommand(cmd string) Option {
+	return func(c *config) {
+		c.embedCommand = cmd
+	}
+}
+
+// Layout set media file path template relative to main destination folder, default is DEFAULTLAYOUT.
+// Fields are {blog} {media} {id} {timestamp} {slug} {tags} {tag} (first tag) {index} (1-based position in post)
+// {width} {name} (original file name) {ext} and blog timezone date {year} {month} {day} {date} {time}
+func Layout(tpl string) Option {
+	return func(c *config) {
+		c.layout = tpl
+	}
+}
+
+// After only process posts published at or after given time, paging stop at first older post
+func After(t time.Time) Option {
+	return func(c *config) {
+		c.after = t
+	}
+}
+
+// Before only process posts published before given time
+func Before(t time.Time) Option {
+	return func(c *config) {
+		c.before = t
+	}
+}
+
+// Tags only process posts which have any of given tags, single tag is passed to api to skip other posts
+func Tags(tags ...string) Option {
+	return func(c *config) {
+		c.tags = tags
+	}
+}
+
+// ExcludeTags skip posts which have any of given tags
+func ExcludeTags(tags ...string) Option {
+	return func(c *config) {
+		c.excludeTags = tags
+	}
+}
+
+// Reblogs set which posts are processed by their origin, REBLOGSALL, REBLOGSNONE (only posts authored
+// by the blog) or REBLOGSONLY, reblog source of kept post is part of its metadata
+func Reblogs(mode string) Option {
+	return func(c *config) {
+		c.reblogs = mode
+	}
+}
+
+// PostIDs only download given posts of the blog instead of crawling every page,
+// it is meant as Download option, see ParsePost
+func PostIDs(ids ...string) Option {
+	return func(c *config) {
+		c.postIDs = ids
+	}
+}
+
+// DryRun only walk pages and list files which would be downloaded with their destination,
+// nothing is written into destination folder
+func DryRun(enable bool) Option {
+	return func(c *config) {
+		c.dryRun = enable
+	}
+}
+
+// PlanOutput write every file listed on dry run as json line of PlannedFile
+func PlanOutput(w io.Writer) Option {
+	return func(c *config) {
+		c.planOut = w
+	}
+}
+
+// New create Downloader from given options
+func New(opts ...Option) (*Downloader, error) {
+	defaultDest, _ := os.Getwd()
+	cfg := config{
+		dest:            defaultDest,
+		media:           DEFAULTMEDIA,
+		batch:           DEFAULTBATCH,
+		connectTimeout:  DEFAULTCTO,
+		downloadTimeout: DEFAULTDTO,
+		perPage:         DEFAULTPERPAGE,
+		queueSize:       DEFAULTQUEUE,
+		pageRetry:       DefaultPageRetry,
+		mediaRetry:      DefaultMediaRetry,
+		metadata:        METANONE,
+		photoSize:       PHOTOLARGEST,
+		layout:          DEFAULTLAYOUT,
+		reblogs:         REBLOGSALL,
+		out:             os.Stdout,
+		backend:         XMLBackend{},
+		baseURL:         BASEURL,
+		client:          &http.Client{},
+	}
+
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	if err := cfg.normalize(); err != nil {
+		return nil, err
+	}
+
+	return &Downloader{cfg: cfg}, nil
+}
+
+// Download process all media of single blog username,
+// given options only apply to this job
+func (d *Downloader) Download(username string, opts ...Option) error {
+	job, err := d.newJob(username, opts...)
+	if err != nil {
+		return err
+	}
+
+	return job.processJob()
+}
+
+// Folder return destination folder and blog folder name which Download use for username
+// with the same options, custom domain is resolved into its blog name
+func (d *Downloader) Folder(username string, opts ...Option) (string, string, error) {
+	job, err := d.newJob(username, opts...)
+	if err != nil {
+		return "", "", err
+	}
+
+	if isDomain(job.username) {
+		if err := job.resolveDomain(); err != nil {
+			return "", "", err
+		}
+	}
+
+	return job.mainFolder, job.username, nil
+}
+
+func (d *Downloader) newJob(username string, opts ...Option) (*tumblrJob, error) {
+	cfg := d.cfg
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	if err := cfg.normalize(); err != nil {
+		return nil, err
+	}
+
+	blog, err := ParseBlog(username)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tumblrJob{
+		username:        blog,
+		mainFolder:      cfg.dest,
+		media:           cfg.media,
+		batch:           cfg.batch,
+		start:           DEFAULTSTART,
+		perPage:         cfg.perPage,
+		limitPage:       cfg.limitPage,
+		connectTimeout:  cfg.connectTimeout,
+		downloadTimeout: cfg.downloadTimeout,
+		out:             cfg.out,
+		backend:         cfg.backend,
+		baseURL:         cfg.baseURL,
+		mediaHost:       cfg.mediaHost,
+		client:          cfg.client,
+		sinceLast:       cfg.sinceLast,
+		queueSize:       cfg.queueSize,
+		pageRetry:       cfg.pageRetry,
+		mediaRetry:      cfg.mediaRetry,
+		metadata:        cfg.metadata,
+		photoWidths:     cfg.photoWidths,
+		photoOriginal:   cfg.photoOriginal,
+		embedCommand:    strings.TrimSpace(cfg.embedCommand),
+		layout:          cfg.layout,
+		after:           cfg.after,
+		before:          cfg.before,
+		tags:            normalizeTags(cfg.tags),
+		excludeTags:     normalizeTags(cfg.excludeTags),
+		reblogs:         cfg.reblogs,
+		postIDs:         cfg.postIDs,
+		dryRun:          cfg.dryRun,
+		planOut:         cfg.planOut,
+		planned:         map[string]*planCount{},
+		zones:           map[string]*time.Location{},
+		downloader:      d,
+	}, nil
+}
+
+// Stats return copy of current accumulated result
+func (d *Downloader) Stats() Stats {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.stats
+}
+
+func (d *Downloader) addPost() {
+	d.mu.Lock()
+	d.stats.Posts++
+	d.mu.Unlock()
+}
+
+func (d *Downloader) addFile(stored, downloaded int64) {
+	d.mu.Lock()
+	d.stats.Files++
+	d.stats.Stored += stored
+	d.stats.Downloaded += downloaded
+	d.mu.Unlock()
+}
+
+func (d *Downloader) addPlanned() {
+	d.mu.Lock()
+	d.stats.Planned++
+	d.mu.Unlock()
+}
+
+func (d *Downloader) addFailedPage() {
+	d.mu.Lock()
+	d.stats.FailedPage++
+	d.mu.Unlock()
+}
+
+func (d *Downloader) addFailedFile() {
+	d.mu.Lock()
+	d.stats.FailedFile++
+	d.mu.Unlock()
+}
+
+func (d *Downloader) addFailedEmbed() {
+	d.mu.Lock()
+	d.stats.FailedEmbed++
+	d.mu.Unlock()
+}
+
+// normalize validate config and clamp value to allowed range
+func (c *config) normalize() error {
+	mt := mediaTypes(c.media)
+	for _, m := range mt {
+		if !allowedMedia[m] {
+			mt = nil
+			break
+		}
+	}
+	if len(mt) == 0 {
+		am := []string{}
+		for m := range allowedMedia {
+			am = append(am, m)
+		}
+		sort.Strings(am)
+		return fmt.Errorf("Allowed media is: %s", strings.Join(am, ","))
+	}
+
+	if c.metadata == "" {
+		c.metadata = METANONE
+	}
+
+	if !allowedMeta[c.metadata] {
+		return fmt.Errorf("Allowed metadata is: %s,%s,%s", METANONE, METAPOST, METAJSONL)
+	}
+
+	if c.reblogs == "" {
+		c.reblogs = REBLOGSALL
+	}
+
+	if !allowedReblogs[c.reblogs] {
+		return fmt.Errorf("Allowed reblogs is: %s,%s,%s", REBLOGSALL, REBLOGSNONE, REBLOGSONLY)
+	}
+
+	widths, err := parsePhotoSize(c.photoSize)
+	if err != nil {
+		return err
+	}
+	c.photoWidths = widths
+
+	if c.layout == "" {
+		c.layout = DEFAULTLAYOUT
+	}
+
+	if err := checkLayout(c.layout); err != nil {
+		return err
+	}
+
+	if !c.after.IsZero() && !c.before.IsZero() && !c.after.Before(c.before) {
+		return fmt.Errorf("After %s must be earlier than before %s", c.after.Format(time.RFC3339), c.before.Format(time.RFC3339))
+	}
+
+	if err := checkDest(c.dest); err != nil {
+		return err
+	}
+
+	if c.batch < 1 {
+		c.batch = 1
+	}
+
+	if c.batch > MAXPERBATCH {
+		c.batch = MAXPERBATCH
+	}
+
+	if c.perPage < 1 {
+		c.perPage = DEFAULTPERPAGE
+	}
+
+	if c.perPage > MAXPERPAGE {
+		c.perPage = MAXPERPAGE
+	}
+
+	if c.queueSize < 1 {
+		c.queueSize = DEFAULTQUEUE
+	}
+
+	if c.pageRetry.Attempts < 1 {
+		c.pageRetry.Attempts = 1
+	}
+
+	if c.mediaRetry.Attempts < 1 {
+		c.mediaRetry.Attempts = 1
+	}
+
+	if c.pageRetry.Backoff < 0 {
+		c.pageRetry.Backoff = 0
+	}
+
+	if c.mediaRetry.Backoff < 0 {
+		c.mediaRetry.Backoff = 0
+	}
+
+	c.pageRetry.Jitter = math.Max(0, math.Min(1, c.pageRetry.Jitter))
+	c.mediaRetry.Jitter = math.Max(0, math.Min(1, c.mediaRetry.Jitter))
+
+	if c.connectTimeout < 1 {
+		c.connectTimeout = DEFAULTCTO
+	}
+
+	if c.downloadTimeout < 1 {
+		c.downloadTimeout = DEFAULTDTO
+	}
+
+	if c.out == nil {
+		c.out = os.Stdout
+	}
+
+	if c.backend == nil {
+		c.backend = XMLBackend{}
+	}
+
+	if c.baseURL == "" {
+		c.baseURL = BASEURL
+	}
+
+	if c.mediaHost != "" {
+		if u, err := url.Parse(c.mediaHost); err != nil || u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("Invalid media host %s", c.mediaHost)
+		}
+	}
+
+	if c.client == nil {
+		c.client = &http.Client{}
+	}
+
+	return nil
+}
+
+func checkDest(dir string) error {
+	abs, absErr := filepath.Abs(dir)
+	if absErr != nil {
+		return fmt.Errorf("Unable to parse %s", abs)
+	}
+
+	s, sErr := os.Stat(abs)
+	if sErr != nil {
+		if os.IsNotExist(sErr) {
+			return fmt.Errorf("Destination folder '%s' not found, create first, I'll do the rest", abs)
+		}
+		return sErr
+	}
+
+	if !s.IsDir() {
+		return fmt.Errorf("Destination '%s' is not a folder", abs)
+	}
+
+	return nil
+}
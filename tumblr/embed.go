@@ -0,0 +1,250 @@
+// Copyright (c) 2016 - Sarjono Mukti Aji <me@simukti.net>
+// Unless otherwise noted, this source code license is MIT-License
+
+package tumblr
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/fatih/color"
+)
+
+const (
+	// EMBEDFILE embedded video list file name, stored inside blog folder
+	EMBEDFILE = "embeds.jsonl"
+
+	// YOUTUBE youtube embedded video provider
+	YOUTUBE = "youtube"
+
+	// VIMEO vimeo embedded video provider
+	VIMEO = "vimeo"
+
+	// INSTAGRAM instagram embedded video provider
+	INSTAGRAM = "instagram"
+)
+
+var (
+	embedURLRgx   = regexp.MustCompile(`\b(?:src|data-instgrm-permalink)=["']([^"']+)["']`)
+	youtubeRgx    = regexp.MustCompile(`^(?:www\.|m\.)?(?:youtube\.com|youtube-nocookie\.com|youtu\.be)$`)
+	youtubeIDRgx  = regexp.MustCompile(`^/(?:embed/|v/)?([\w-]{11})$`)
+	vimeoIDRgx    = regexp.MustCompile(`^/(?:video/)?(\d+)$`)
+	instagramRgx  = regexp.MustCompile(`^/(p|reel|tv)/([\w-]+)`)
+	embedProvider = map[string]string{"vimeo.com": VIMEO, "player.vimeo.com": VIMEO, "instagram.com": INSTAGRAM, "www.instagram.com": INSTAGRAM}
+)
+
+// EmbeddedVideo single video which is not hosted by tumblr, it is left for external tool
+type EmbeddedVideo struct {
+	PostID    string `json:"post_id"`
+	Timestamp int    `json:"timestamp"`
+	Provider  string `json:"provider"`
+	URL       string `json:"url"`               // canonical url of provider page
+	Command   string `json:"command,omitempty"` // STATUSDONE or STATUSFAILED of EmbedCommand
+}
+
+// embedList append only list of embedded videos of single blog,
+// later record of the same url replace the previous one
+type embedList struct {
+	mu     sync.Mutex
+	path   string
+	w      *os.File // opened on first record
+	videos map[string]EmbeddedVideo
+}
+
+func openEmbedList(path string) *embedList {
+	l := &embedList{path: path, videos: map[string]EmbeddedVideo{}}
+	r, err := os.Open(path)
+	if err != nil {
+		return l
+	}
+	defer r.Close()
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		e := EmbeddedVideo{}
+		if json.Unmarshal(scanner.Bytes(), &e) == nil && e.URL != "" {
+			l.videos[e.URL] = e
+		}
+	}
+
+	return l
+}
+
+func (l *embedList) lookup(videoURL string) (EmbeddedVideo, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	e, ok := l.videos[videoURL]
+
+	return e, ok
+}
+
+func (l *embedList) record(e EmbeddedVideo) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.w == nil {
+		w, err := os.OpenFile(l.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+		if err != nil {
+			return err
+		}
+		l.w = w
+	}
+
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	l.videos[e.URL] = e
+	_, err = l.w.Write(append(b, '\n'))
+
+	return err
+}
+
+func (l *embedList) close() error {
+	if l.w == nil {
+		return nil
+	}
+
+	return l.w.Close()
+}
+
+// embeddedVideo provider and canonical url of first video found in embed code,
+// unknown provider is named by its host
+func embeddedVideo(code string) (string, string, bool) {
+	provider, canonical := "", ""
+	for _, match := range embedURLRgx.FindAllStringSubmatch(code, -1) {
+		raw := match[1]
+		if strings.HasPrefix(raw, "//") {
+			raw = "https:" + raw
+		}
+		u, err := url.Parse(raw)
+		if err != nil || u.Host == "" || strings.HasSuffix(u.Hostname(), "tumblr.com") {
+			continue
+		}
+
+		if p, c, ok := canonicalVideo(u); ok {
+			return p, c, true
+		}
+		if provider == "" {
+			provider, canonical = strings.TrimPrefix(u.Hostname(), "www."), u.String()
+		}
+	}
+
+	return provider, canonical, provider != ""
+}
+
+// canonicalVideo page url of youtube, vimeo or instagram video
+func canonicalVideo(u *url.URL) (string, string, bool) {
+	host := strings.ToLower(u.Hostname())
+	if youtubeRgx.MatchString(host) {
+		id := u.Query().Get("v")
+		if m := youtubeIDRgx.FindStringSubmatch(u.Path); id == "" && len(m) == 2 {
+			id = m[1]
+		}
+		if id != "" {
+			return YOUTUBE, "https://www.youtube.com/watch?v=" + id, true
+		}
+	}
+
+	switch embedProvider[host] {
+	case VIMEO:
+		if m := vimeoIDRgx.FindStringSubmatch(u.Path); len(m) == 2 {
+			return VIMEO, "https://vimeo.com/" + m[1], true
+		}
+	case INSTAGRAM:
+		if m := instagramRgx.FindStringSubmatch(u.Path); len(m) == 3 {
+			return INSTAGRAM, fmt.Sprintf("https://www.instagram.com/%s/%s/", m[1], m[2]), true
+		}
+	}
+
+	return "", "", false
+}
+
+// processEmbed record embedded video of non direct video post,
+// EmbedCommand is called with its url if it was not successfully called before.
+// It return false if EmbedCommand failed.
+func (job *tumblrJob) processEmbed(t *Tumblr, p *Post) bool {
+	provider, videoURL, ok := "", "", false
+	if p.EmbedURL != "" {
+		if u, err := url.Parse(p.EmbedURL); err == nil {
+			provider, videoURL, ok = canonicalVideo(u)
+		}
+	}
+	for _, vp := range p.VideoPlayer {
+		if ok {
+			break
+		}
+		provider, videoURL, ok = embeddedVideo(vp.Content)
+	}
+	if !ok {
+		fmt.Fprintln(job.out, color.YellowString("\t[SKIPPED] [%s] [NO VIDEO FILE OR EMBED]", p.ID))
+		return true
+	}
+	if job.dryRun {
+		fmt.Fprintln(job.out, color.WhiteString("\t[EMBEDDED] [%s] [%s]", provider, videoURL))
+		return true
+	}
+
+	e, known := job.embeds.lookup(videoURL)
+	if known && (job.embedCommand == "" || e.Command == STATUSDONE) {
+		fmt.Fprintln(job.out, color.WhiteString("\t[EMBEDDED] [%s] [%s]", e.Provider, e.URL))
+		return true
+	}
+
+	e = EmbeddedVideo{PostID: p.ID, Timestamp: p.Timestamp, Provider: provider, URL: videoURL}
+	fmt.Fprintln(job.out, color.GreenString("\t[EMBEDDED] [%s] [%s]", e.Provider, e.URL))
+	if job.embedCommand != "" {
+		e.Command = STATUSDONE
+		if err := job.runEmbedCommand(t, p, e); err != nil {
+			e.Command = STATUSFAILED
+			job.downloader.addFailedEmbed()
+			msg := color.New(color.FgHiRed, color.Bold).
+				SprintfFunc()("\t[ERROR EMBED COMMAND] [%s] %s", e.URL, err.Error())
+			fmt.Fprintln(job.out, msg)
+		}
+	}
+
+	if err := job.embeds.record(e); err != nil {
+		msg := color.New(color.FgHiRed, color.Bold).SprintfFunc()("\t[ERROR EMBED LIST] %s", err.Error())
+		fmt.Fprintln(job.out, msg)
+	}
+
+	return e.Command != STATUSFAILED
+}
+
+// runEmbedCommand run EmbedCommand with video url as last argument inside folder
+// which layout give to files of the post, it is created if not exists yet
+func (job *tumblrJob) runEmbedCommand(t *Tumblr, p *Post, e EmbeddedVideo) error {
+	file, err := t.postFile(job, p, "")
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(file)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+
+	args := strings.Fields(job.embedCommand)
+	cmd := exec.Command(args[0], append(args[1:], e.URL)...)
+	cmd.Dir = dir
+	cmd.Stdout = job.out
+	cmd.Stderr = job.out
+	cmd.Env = append(os.Environ(),
+		"TMD_BLOG="+job.username,
+		"TMD_POST_ID="+e.PostID,
+		"TMD_TIMESTAMP="+strconv.Itoa(e.Timestamp),
+		"TMD_PROVIDER="+e.Provider,
+	)
+
+	return cmd.Run()
+}
@@ -0,0 +1,434 @@
+// Copyright (c) 2016 - Sarjono Mukti Aji <me@simukti.net>
+// Unless otherwise noted, this source code license is MIT-License
+
+package tumblr
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	// FAKEBLOG blog name served by fakeTumblr
+	FAKEBLOG = "fake"
+
+	// FAKEMEDIAHOST media host used in fake posts, it is rewritten into fakeTumblr by MediaHost
+	FAKEMEDIAHOST = "http://68.media.tumblr.com"
+)
+
+var fakeModTime = time.Unix(1500000000, 0)
+
+// fakeTumblr stand-in tumblr server of single blog under any name, posts are legacy api xml post elements
+// per api type (newest first), api v2 posts are json post objects served under /v2/blog/,
+// any other path is served as media file
+type fakeTumblr struct {
+	*httptest.Server
+	mu         sync.Mutex
+	timezone   string
+	posts      map[string][]string
+	jsonPosts  map[string][]string
+	jsonStatus int                         // meta status of api v2 response, 0 is 200
+	media      map[string]http.HandlerFunc // custom handler per media path
+	queries    []url.Values                // every api page request
+	hits       map[string]int              // requests per media path
+	ranges     []string                    // Range header of every media request which has it
+	failPage   int                         // next api requests answered with 503
+}
+
+func newFakeTumblr() *fakeTumblr {
+	f := &fakeTumblr{
+		timezone:  "UTC",
+		posts:     map[string][]string{},
+		jsonPosts: map[string][]string{},
+		media:     map[string]http.HandlerFunc{},
+		hits:      map[string]int{},
+	}
+	f.Server = httptest.NewServer(http.HandlerFunc(f.serve))
+
+	return f
+}
+
+// addPosts append posts of given api type, older than already added ones
+func (f *fakeTumblr) addPosts(apiType string, posts ...string) {
+	f.mu.Lock()
+	f.posts[apiType] = append(f.posts[apiType], posts...)
+	f.mu.Unlock()
+}
+
+// addJSONPosts append api v2 posts of given post type, older than already added ones
+func (f *fakeTumblr) addJSONPosts(postType string, posts ...string) {
+	f.mu.Lock()
+	f.jsonPosts[postType] = append(f.jsonPosts[postType], posts...)
+	f.mu.Unlock()
+}
+
+// jsonAPI api v2 backend of fake server
+func (f *fakeTumblr) jsonAPI() Option {
+	return API(JSONBackend{APIKey: "key", BaseURL: f.URL + "/v2/blog/%s/posts/%s"})
+}
+
+// prependPosts add posts of given api type which are newer than already added ones
+func (f *fakeTumblr) prependPosts(apiType string, posts ...string) {
+	f.mu.Lock()
+	f.posts[apiType] = append(append([]string{}, posts...), f.posts[apiType]...)
+	f.mu.Unlock()
+}
+
+// handleMedia replace default media file of given path
+func (f *fakeTumblr) handleMedia(path string, h http.HandlerFunc) {
+	f.mu.Lock()
+	f.media[path] = h
+	f.mu.Unlock()
+}
+
+// pageStarts start offset of every api page request which fetch posts, in request order
+func (f *fakeTumblr) pageStarts() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	starts := []string{}
+	for _, q := range f.queries {
+		if q.Get("num") != "0" && q.Get("id") == "" {
+			starts = append(starts, q.Get("start"))
+		}
+	}
+
+	return starts
+}
+
+func (f *fakeTumblr) hitCount(path string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.hits[path]
+}
+
+func (f *fakeTumblr) serve(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "HEAD" {
+		return
+	}
+	// custom domain is served as the same blog
+	if strings.HasSuffix(r.URL.Path, "/api/read") {
+		f.serveAPI(w, r)
+		return
+	}
+	if strings.HasPrefix(r.URL.Path, "/v2/blog/") {
+		f.serveJSON(w, r)
+		return
+	}
+
+	f.mu.Lock()
+	f.hits[r.URL.Path]++
+	if rg := r.Header.Get("Range"); rg != "" {
+		f.ranges = append(f.ranges, rg)
+	}
+	h, ok := f.media[r.URL.Path]
+	f.mu.Unlock()
+
+	if ok {
+		h(w, r)
+		return
+	}
+	serveMedia(w, r)
+}
+
+func (f *fakeTumblr) serveAPI(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.queries = append(f.queries, q)
+	if f.failPage > 0 {
+		f.failPage--
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	posts := f.posts[q.Get("type")]
+	if id := q.Get("id"); id != "" {
+		posts = []string{}
+		for _, typed := range f.posts {
+			for _, p := range typed {
+				if strings.HasPrefix(p, `<post id="`+id+`"`) {
+					posts = append(posts, p)
+				}
+			}
+		}
+	}
+	total := len(posts)
+
+	start, _ := strconv.Atoi(q.Get("start"))
+	num, _ := strconv.Atoi(q.Get("num"))
+	if q.Get("id") == "" {
+		if start > len(posts) {
+			start = len(posts)
+		}
+		end := start + num
+		if end > len(posts) {
+			end = len(posts)
+		}
+		posts = posts[start:end]
+	}
+
+	w.Header().Set("Content-Type", "text/xml")
+	fmt.Fprintf(w, `<?xml version="1.0"?><tumblr version="1.0"><tumblelog name="%s" timezone="%s"></tumblelog>`+
+		`<posts start="%d" total="%d">%s</posts></tumblr>`, FAKEBLOG, f.timezone, start, total, strings.Join(posts, ""))
+}
+
+// serveJSON api v2 /v2/blog/{blog}/posts/{type}, single post is requested without type
+func (f *fakeTumblr) serveJSON(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.queries = append(f.queries, q)
+	status, msg := http.StatusOK, "OK"
+	switch {
+	case q.Get("api_key") == "":
+		status, msg = http.StatusUnauthorized, "Unauthorized"
+	case f.jsonStatus != 0:
+		status, msg = f.jsonStatus, http.StatusText(f.jsonStatus)
+	}
+
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/v2/blog/"), "/")
+	posts := []string{}
+	if len(parts) == 3 {
+		posts = f.jsonPosts[parts[2]]
+	}
+	if id := q.Get("id"); id != "" {
+		for _, typed := range f.jsonPosts {
+			for _, p := range typed {
+				if strings.HasPrefix(p, `{"id":`+id+`,`) {
+					posts = append(posts, p)
+				}
+			}
+		}
+	}
+	total := len(posts)
+
+	offset, _ := strconv.Atoi(q.Get("offset"))
+	limit, _ := strconv.Atoi(q.Get("limit"))
+	if offset > len(posts) {
+		offset = len(posts)
+	}
+	end := offset + limit
+	if end > len(posts) {
+		end = len(posts)
+	}
+	posts = posts[offset:end]
+
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, `{"meta":{"status":%d,"msg":"%s"},"response":{"blog":{"name":"%s"},"total_posts":%d,"posts":[%s]}}`,
+		status, msg, FAKEBLOG, total, strings.Join(posts, ","))
+}
+
+// mediaBody content of default media file, it differ per path
+func mediaBody(path string) []byte {
+	return []byte(strings.Repeat("x", 1000) + path)
+}
+
+// serveMedia default media file, content type follow path prefix and Range request is supported
+func serveMedia(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case strings.HasPrefix(r.URL.Path, "/video_file/"):
+		w.Header().Set("Content-Type", "video/mp4")
+	case strings.HasPrefix(r.URL.Path, "/audio_file/"):
+		w.Header().Set("Content-Type", "audio/mpeg")
+	default:
+		w.Header().Set("Content-Type", "image/jpeg")
+	}
+	http.ServeContent(w, r, "", fakeModTime, bytes.NewReader(mediaBody(r.URL.Path)))
+}
+
+// photoPost photo post with 1280 and 500 variant of every given photo name,
+// more than one photo is a photoset
+func photoPost(id string, ts int, names ...string) string {
+	variants := func(name string) string {
+		return fmt.Sprintf(`<photo-url max-width="1280">%s/p/%s_1280.jpg</photo-url>`+
+			`<photo-url max-width="500">%s/p/%s_500.jpg</photo-url>`, FAKEMEDIAHOST, name, FAKEMEDIAHOST, name)
+	}
+
+	body := variants(names[0])
+	if len(names) > 1 {
+		body += "<photoset>"
+		for i, name := range names {
+			body += fmt.Sprintf(`<photo offset="o%d">%s</photo>`, i+1, variants(name))
+		}
+		body += "</photoset>"
+	}
+
+	return fmt.Sprintf(`<post id="%s" unix-timestamp="%d" type="photo" slug="slug-%s">%s</post>`, id, ts, id, body)
+}
+
+// videoPost direct video post of tumblr hosted file
+func videoPost(id string, ts int, name string) string {
+	return fmt.Sprintf(`<post id="%s" unix-timestamp="%d" type="video" direct-video="true">`+
+		`<video-source><extension>mp4</extension><width>720</width></video-source>`+
+		`<video-player>&lt;video&gt;&lt;source src="%s/video_file/%s" type="video/mp4"&gt;&lt;/video&gt;</video-player></post>`,
+		id, ts, FAKEMEDIAHOST, name)
+}
+
+// embedPost video post of embedded player
+func embedPost(id string, ts int, src string) string {
+	return fmt.Sprintf(`<post id="%s" unix-timestamp="%d" type="video">`+
+		`<video-player max-width="400">&lt;iframe src="%s"&gt;&lt;/iframe&gt;</video-player></post>`, id, ts, src)
+}
+
+// jsonPhotoPost api v2 photo post with 1280, 500 and 250 alt size of every given photo name,
+// original size is the 1280 one
+func jsonPhotoPost(id string, ts int, names ...string) string {
+	photos := []string{}
+	for _, name := range names {
+		size := func(w int) string {
+			return fmt.Sprintf(`{"url":"%s/p/%s_%d.jpg","width":%d,"height":%d}`, FAKEMEDIAHOST, name, w, w, w)
+		}
+		photos = append(photos, fmt.Sprintf(`{"caption":"","original_size":%s,"alt_sizes":[%s,%s,%s]}`,
+			size(1280), size(1280), size(500), size(250)))
+	}
+
+	return fmt.Sprintf(`{"id":%s,"type":"photo","timestamp":%d,"slug":"slug-%s","photos":[%s]}`,
+		id, ts, id, strings.Join(photos, ","))
+}
+
+// jsonVideoPost api v2 direct video post of tumblr hosted mp4 file
+func jsonVideoPost(id string, ts int, name string) string {
+	src := fmt.Sprintf("%s/video_file/%s.mp4", FAKEMEDIAHOST, name)
+
+	return fmt.Sprintf(`{"id":%s,"type":"video","timestamp":%d,"video_type":"tumblr","video_url":"%s","duration":5,`+
+		`"player":[{"width":400,"embed_code":"<video><source src=\"%s\" type=\"video/mp4\"></video>"}]}`, id, ts, src, src)
+}
+
+// jsonEmbedPost api v2 video post of embedded player, unavailable player has false embed code
+func jsonEmbedPost(id string, ts int, provider, permalink, src string) string {
+	return fmt.Sprintf(`{"id":%s,"type":"video","timestamp":%d,"video_type":"%s","permalink_url":"%s",`+
+		`"player":[{"width":250,"embed_code":false},{"width":400,"embed_code":"<iframe src=\"%s\"></iframe>"}]}`,
+		id, ts, provider, permalink, src)
+}
+
+// withTags add tags into post
+func withTags(post string, tags ...string) string {
+	t := ""
+	for _, tag := range tags {
+		t += "<tag>" + tag + "</tag>"
+	}
+
+	return strings.TrimSuffix(post, "</post>") + t + "</post>"
+}
+
+// reblogged mark post as reblogged from other blog
+func reblogged(post string) string {
+	return strings.Replace(post, "<post ", `<post reblogged-from-name="other" reblogged-from-url="http://other.tumblr.com/post/1" `, 1)
+}
+
+// photoPosts n photo posts of single photo, newest first, id and timestamp decrease by one per post
+func photoPosts(firstID, firstTS, n int) []string {
+	posts := []string{}
+	for i := 0; i < n; i++ {
+		id := strconv.Itoa(firstID - i)
+		posts = append(posts, photoPost(id, firstTS-i*86400, "tumblr_"+id))
+	}
+
+	return posts
+}
+
+// photoFile destination of single photo of photoPosts with default layout
+func photoFile(dest string, id, ts int) string {
+	return filepath.Join(dest, FAKEBLOG, PHOTO, fmt.Sprintf("%d_%d_tumblr_%d_1280.jpg", id, ts, id))
+}
+
+// lockedBuffer progress output written by several download workers
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.buf.String()
+}
+
+// download run single job of fake blog into dest, retry never wait
+func (f *fakeTumblr) download(t *testing.T, dest string, opts ...Option) (Stats, string) {
+	t.Helper()
+
+	out := &lockedBuffer{}
+	base := []Option{
+		Destination(dest),
+		BaseURL(f.URL + "/%s"),
+		MediaHost(f.URL),
+		HTTPClient(f.Client()),
+		Output(out),
+		PerPage(2),
+		PageRetry(RetryPolicy{Attempts: 2, Backoff: time.Millisecond}),
+		MediaRetry(RetryPolicy{Attempts: 2, Backoff: time.Millisecond}),
+	}
+	d, err := New(append(base, opts...)...)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	if err := d.Download(FAKEBLOG); err != nil {
+		t.Fatalf("Download: %v\n%s", err, out.String())
+	}
+
+	return d.Stats(), out.String()
+}
+
+// tempDest empty destination folder, it is removed by returned func
+func tempDest(t *testing.T) (string, func()) {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "tmd-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return dir, func() { os.RemoveAll(dir) }
+}
+
+// readManifest manifest of fake blog inside dest
+func readManifest(t *testing.T, dest string) *Manifest {
+	t.Helper()
+
+	m, err := ReadManifest(filepath.Join(dest, FAKEBLOG, MANIFESTFILE))
+	if err != nil {
+		t.Fatalf("ReadManifest: %v", err)
+	}
+
+	return m
+}
+
+func mustExist(t *testing.T, path string) {
+	t.Helper()
+
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("expected file %s: %v", path, err)
+	}
+}
+
+func mustNotExist(t *testing.T, path string) {
+	t.Helper()
+
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("unexpected file %s", path)
+	}
+}
@@ -0,0 +1,111 @@
+// Copyright (c) 2016 - Sarjono Mukti Aji <me@simukti.net>
+// Unless otherwise noted, this source code license is MIT-License
+
+package tumblr
+
+import (
+	"strings"
+	"time"
+)
+
+const (
+	// REBLOGSALL process original and reblogged posts
+	REBLOGSALL = "all"
+
+	// REBLOGSNONE only process posts authored by the blog
+	REBLOGSNONE = "none"
+
+	// REBLOGSONLY only process posts reblogged from other blog
+	REBLOGSONLY = "only"
+)
+
+var allowedReblogs = map[string]bool{REBLOGSALL: true, REBLOGSNONE: true, REBLOGSONLY: true}
+
+// filtered check if only part of blog posts is processed,
+// such run does not record page progress nor sync mark
+func (job *tumblrJob) filtered() bool {
+	return !job.after.IsZero() || !job.before.IsZero() || len(job.tags) > 0 || len(job.excludeTags) > 0 ||
+		job.reblogs != REBLOGSALL
+}
+
+// matchReblog check if post is original or reblog as configured
+func (job *tumblrJob) matchReblog(p *Post) bool {
+	switch job.reblogs {
+	case REBLOGSNONE:
+		return !p.isReblog()
+	case REBLOGSONLY:
+		return p.isReblog()
+	}
+
+	return true
+}
+
+// apiTag single included tag which api can filter, any of several tags can not be expressed
+func (job *tumblrJob) apiTag() string {
+	if len(job.tags) == 1 {
+		return job.tags[0]
+	}
+
+	return ""
+}
+
+// matchTags check if post has any of included tags and none of excluded tags, tags are case insensitive
+func (job *tumblrJob) matchTags(p *Post) bool {
+	if len(job.tags) == 0 && len(job.excludeTags) == 0 {
+		return true
+	}
+
+	has := map[string]bool{}
+	for _, t := range p.Tags {
+		has[strings.ToLower(strings.TrimSpace(t))] = true
+	}
+
+	for _, t := range job.excludeTags {
+		if has[t] {
+			return false
+		}
+	}
+
+	for _, t := range job.tags {
+		if has[t] {
+			return true
+		}
+	}
+
+	return len(job.tags) == 0
+}
+
+// normalizeTags lower case and trim tags, leading # and empty tag are removed
+func normalizeTags(tags []string) []string {
+	nt := []string{}
+	for _, t := range tags {
+		t = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(t), "#")))
+		if t != "" {
+			nt = append(nt, t)
+		}
+	}
+
+	return nt
+}
+
+// dropOutside remove posts outside of [after, before) window, zero time is unbounded.
+// Return true if any post is older than window, since api return newest post first
+// older page will not contain post inside window.
+func (t *Tumblr) dropOutside(after, before time.Time) bool {
+	posts := []Post{}
+	older := false
+	for _, p := range t.Posts.Posts {
+		ts := time.Unix(int64(p.Timestamp), 0)
+		if !after.IsZero() && ts.Before(after) {
+			older = true
+			continue
+		}
+		if !before.IsZero() && !ts.Before(before) {
+			continue
+		}
+		posts = append(posts, p)
+	}
+	t.Posts.Posts = posts
+
+	return older
+}
@@ -0,0 +1,503 @@
+// Copyright (c) 2016 - Sarjono Mukti Aji <me@simukti.net>
+// Unless otherwise noted, this source code license is MIT-License
+
+package tumblr
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+var (
+	sourceRgx    = regexp.MustCompile(`<source[^>]+\bsrc=["']([^"']+)["']`)
+	audioFileRgx = regexp.MustCompile(`[?&](?:amp;)?audio_file=([^&"']+)`)
+	embedSrcRgx  = regexp.MustCompile(`\bsrc=["']([^"']+)["']`)
+)
+
+// tumblrJob main tumblr download jobs per username and media
+type tumblrJob struct {
+	username        string
+	mainFolder      string
+	media           string
+	batch           int
+	connectTimeout  int
+	downloadTimeout int
+	start           int
+	perPage         int
+	limitPage       int
+	out             io.Writer
+	backend         Backend
+	baseURL         string
+	mediaHost       string
+	client          *http.Client
+	manifest        *Manifest
+	queue           *downloadQueue
+	meta            *metaWriter
+	metadata        string
+	queueSize       int
+	pageRetry       RetryPolicy
+	mediaRetry      RetryPolicy
+	sinceLast       bool
+	photoWidths     []int
+	photoOriginal   bool
+	embeds          *embedList
+	embedCommand    string
+	layout          string
+	after           time.Time
+	before          time.Time
+	tags            []string
+	excludeTags     []string
+	reblogs         string
+	postIDs         []string
+	dryRun          bool
+	planOut         io.Writer
+	planned         map[string]*planCount     // files listed on dry run per media
+	zones           map[string]*time.Location // loaded blog timezone
+	downloader      *Downloader
+}
+
+// httpClient copy of configured client with given timeout in seconds
+func (job *tumblrJob) httpClient(timeout int) *http.Client {
+	c := *job.client
+	c.Timeout = time.Second * time.Duration(timeout)
+
+	return &c
+}
+
+// blogURL base url of blog name or custom domain, custom domain is its own host
+// unless BaseURL option is set
+func (job *tumblrJob) blogURL(blog string) string {
+	if isDomain(blog) && job.baseURL == BASEURL {
+		return fmt.Sprintf(DOMAINURL, blog)
+	}
+
+	return fmt.Sprintf(job.baseURL, blog)
+}
+
+// mediaURL rewrite media url host if MediaHost option is set
+func (job *tumblrJob) mediaURL(fileURL string) string {
+	if job.mediaHost == "" {
+		return fileURL
+	}
+
+	fu, err := url.Parse(fileURL)
+	if err != nil {
+		return fileURL
+	}
+	mu, _ := url.Parse(job.mediaHost)
+	fu.Scheme = mu.Scheme
+	fu.Host = mu.Host
+	fu.Path = strings.TrimSuffix(mu.Path, "/") + fu.Path
+
+	return fu.String()
+}
+
+func (job *tumblrJob) processJob() error {
+	if isDomain(job.username) {
+		if err := job.resolveDomain(); err != nil {
+			return err
+		}
+	}
+
+	useragent := defaultUserAgents[rand.Intn(len(defaultUserAgents))]
+	client := job.httpClient(job.connectTimeout)
+	userURL := job.blogURL(job.username)
+	headReq, _ := http.NewRequest("HEAD", userURL, nil) // check username existence
+	headReq.Header.Set("User-Agent", useragent)
+	headResp, headErr := client.Do(headReq)
+	headError := fmt.Errorf("Unable to parse %s", userURL)
+	if headErr != nil {
+		return headError
+	}
+	defer headResp.Body.Close()
+
+	if headResp.StatusCode != http.StatusOK {
+		return headError
+	}
+
+	mediaType := mediaTypes(job.media)
+
+	userDir := filepath.Join(job.mainFolder, job.username)
+	if _, cErr := os.Stat(userDir); os.IsNotExist(cErr) && !job.dryRun {
+		if err := os.Mkdir(filepath.Join(job.mainFolder, job.username), 0700); err != nil {
+			return err
+		}
+	}
+
+	openManifest, metadata := OpenManifest, job.metadata
+	if job.dryRun {
+		openManifest, metadata = dryRunManifest, METANONE
+	}
+	manifest, err := openManifest(filepath.Join(userDir, MANIFESTFILE))
+	if err != nil {
+		return err
+	}
+	defer manifest.Close()
+	job.manifest = manifest
+
+	meta, err := newMetaWriter(metadata, userDir)
+	if err != nil {
+		return err
+	}
+	defer meta.close()
+	job.meta = meta
+
+	embeds := openEmbedList(filepath.Join(userDir, EMBEDFILE))
+	defer embeds.close()
+	job.embeds = embeds
+
+	// every queued file must be finished before manifest is closed
+	job.queue = newDownloadQueue(job)
+	defer job.queue.wait()
+
+	if job.dryRun {
+		defer job.planSummary()
+	}
+
+	if len(job.postIDs) > 0 {
+		return job.processPosts(userURL)
+	}
+
+	for _, m := range mediaType {
+		job.media = m
+		mJob := &mediaJob{
+			userURL: userURL,
+			mainJob: job,
+		}
+
+		if err := mJob.processMedia(); err != nil {
+			job.downloader.addFailedPage()
+			msg := color.New(color.FgHiRed, color.Bold).
+				SprintfFunc()("[ERROR] %s", err.Error())
+			// don't cancel job
+			fmt.Fprintln(job.out, msg)
+		}
+	}
+
+	return nil
+}
+
+type mediaJob struct {
+	userURL string
+	mainJob *tumblrJob
+}
+
+func (m *mediaJob) processMedia() error {
+	out := m.mainJob.out
+	client := m.mainJob.httpClient(m.mainJob.connectTimeout)
+	query := Query{
+		Blog:    m.mainJob.username,
+		BlogURL: m.userURL,
+		Media:   m.mainJob.media,
+		Tag:     m.mainJob.apiTag(),
+	}
+	var blog *Tumblr
+	err := m.mainJob.pageRetry.do(out, "PAGE 0", func() (err error) {
+		blog, err = m.mainJob.backend.Posts(client, query)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	currentPage := 0
+	startAt := m.mainJob.start
+	manifest := m.mainJob.manifest
+	countTotal := float64(blog.Posts.Total) / float64(m.mainJob.perPage)
+	totalPage := int(math.Ceil(countTotal))
+
+	if m.mainJob.limitPage != 0 && m.mainJob.limitPage < totalPage {
+		if m.mainJob.limitPage < 0 {
+			totalPage = 1
+			fmt.Fprintln(out, color.GreenString("[INFO] REVERT LIMIT PAGE TO: %d", totalPage))
+		} else {
+			totalPage = m.mainJob.limitPage
+			fmt.Fprintln(out, color.GreenString("[INFO] SET LIMIT PAGE TO: %d", totalPage))
+		}
+	} else {
+		fmt.Fprintln(out, color.GreenString("[INFO] TOTAL PAGE: %d", totalPage))
+	}
+
+	lastSync, hasSync := manifest.LastSync(m.mainJob.media)
+	hasSync = hasSync && m.mainJob.sinceLast
+	newest := lastSync
+	newest.Media = m.mainJob.media
+
+	// resume interrupted run from the page after last completed one,
+	// sync mode always start from newest post down to last synced one
+	filtered := m.mainJob.filtered()
+	if p, ok := manifest.Progress(m.mainJob.media); ok && !p.Complete && !hasSync && !filtered &&
+		p.PerPage == m.mainJob.perPage && p.Page < totalPage {
+		currentPage = p.Page
+		fmt.Fprintln(out, color.GreenString("[INFO] RESUME FROM PAGE: %d", currentPage+1))
+	}
+
+	// progress only move forward while every previous page succeed,
+	// filtered run skip posts so its progress is not recorded, dry run never record anything
+	trackTo := manifest
+	if filtered || m.mainJob.dryRun {
+		trackTo = nil
+	}
+	tracker := newPageTracker(trackTo, m.mainJob.media, m.mainJob.perPage, totalPage, currentPage)
+	reachedSync, reachedOlder := false, false
+	for currentPage < totalPage && !reachedSync && !reachedOlder {
+		currentPage++
+		startAt = m.mainJob.start + (m.mainJob.perPage * (currentPage - 1))
+		query.Start = startAt
+		query.Num = m.mainJob.perPage
+		var blogPage *Tumblr
+		pageErr := m.mainJob.pageRetry.do(out, fmt.Sprintf("PAGE %d", currentPage), func() (err error) {
+			blogPage, err = m.mainJob.backend.Posts(client, query)
+			return err
+		})
+
+		if pageErr != nil {
+			m.mainJob.downloader.addFailedPage()
+			msg := color.New(color.FgHiRed, color.Bold).
+				SprintfFunc()("[ERROR PAGE %d] [%s]", currentPage, pageErr.Error())
+			fmt.Fprintln(out, msg)
+		} else {
+			fmt.Fprintln(out,
+				color.CyanString(
+					"\n====================================[%s] [%s] [PAGE %d/%d]====================================",
+					strings.ToUpper(m.userURL),
+					strings.ToUpper(m.mainJob.media),
+					currentPage,
+					totalPage,
+				))
+
+			for _, p := range blogPage.Posts.Posts {
+				if p.newerThan(newest.PostID, newest.Timestamp) {
+					newest.PostID = p.ID
+					newest.Timestamp = p.Timestamp
+				}
+			}
+
+			if hasSync {
+				reachedSync = blogPage.dropSynced(lastSync)
+			}
+			if filtered {
+				reachedOlder = blogPage.dropOutside(m.mainJob.after, m.mainJob.before)
+			}
+
+			tracker.open(currentPage)
+			blogPage.processPage(m.mainJob, tracker, currentPage)
+			tracker.finish(currentPage)
+
+			if reachedSync {
+				fmt.Fprintln(out, color.GreenString("[INFO] REACHED LAST SYNCED POST: %s", lastSync.PostID))
+			}
+			if reachedOlder {
+				fmt.Fprintln(out, color.GreenString("[INFO] REACHED POSTS OLDER THAN: %s", m.mainJob.after.Format(time.RFC3339)))
+			}
+		}
+	}
+
+	var mark *SyncMark
+	if newest.PostID != "" && !filtered && !m.mainJob.dryRun {
+		mark = &newest
+	}
+	tracker.stop(currentPage, mark)
+
+	return nil
+}
+
+// dropSynced remove posts which are not newer than sync mark,
+// return true if any post removed since older page will not contain newer post
+func (t *Tumblr) dropSynced(mark SyncMark) bool {
+	posts := []Post{}
+	for _, p := range t.Posts.Posts {
+		if p.newerThan(mark.PostID, mark.Timestamp) {
+			posts = append(posts, p)
+		}
+	}
+	dropped := len(posts) < len(t.Posts.Posts)
+	t.Posts.Posts = posts
+
+	return dropped
+}
+
+// processPage queue every file of page posts which is not downloaded yet,
+// tracker is nil if page progress is not tracked
+func (t *Tumblr) processPage(job *tumblrJob, tracker *pageTracker, page int) {
+	for _, p := range t.Posts.Posts {
+		if p.Type != postTypes[job.media] || !job.matchTags(&p) || !job.matchReblog(&p) {
+			continue
+		}
+		job.downloader.addPost()
+
+		// audio post is archived as document and its hosted audio file is downloaded next to it
+		if documentMedia[job.media] && !job.processDocument(t, &p) && tracker != nil {
+			tracker.fail(page)
+		}
+
+		pl := []*fileToDownload{}
+		if p.Type == PHOTO {
+			pl = t.getPhotoFileJob(&p, job)
+		}
+		if p.Type == VIDEO {
+			pl = t.getVideoFileJob(&p, job)
+			if !p.IsDirectVideo && !job.processEmbed(t, &p) && tracker != nil {
+				tracker.fail(page)
+			}
+		}
+		if p.Type == AUDIO {
+			pl = t.getAudioFileJob(&p, job)
+		}
+
+		// document already has its metadata
+		var pr *postRecord
+		if job.meta.mode != METANONE && !documentMedia[job.media] {
+			var err error
+			if pr, err = newPostRecord(job, t, &p, len(pl)); err != nil {
+				msg := color.New(color.FgHiRed, color.Bold).SprintfFunc()("\t[ERROR] [%s] %s", p.ID, err.Error())
+				fmt.Fprintln(job.out, msg)
+			}
+		}
+
+		for i, fd := range pl {
+			if fd.err != nil {
+				job.downloader.addFailedFile()
+				msg := color.New(color.FgHiRed, color.Bold).SprintfFunc()("\t[ERROR] [%s] %s", p.ID, fd.err.Error())
+				fmt.Fprintln(job.out, msg)
+				if tracker != nil {
+					tracker.fail(page)
+				}
+				continue
+			}
+			if job.dryRun {
+				job.plan(p.ID, fd.url, fd.destFile)
+				continue
+			}
+			if e, ok := job.manifest.Lookup(fd.url); ok {
+				if e.intact(job.mainFolder) {
+					job.downloader.addFile(e.Size, 0)
+					fmt.Fprintln(job.out, color.WhiteString("\t[DOWNLOADED] [%s]", e.Path(job.mainFolder)))
+					if pr != nil {
+						pr.queue()
+						pr.finish(i, e.Path(job.mainFolder), false)
+					}
+					continue
+				}
+				if e.Status == STATUSDONE {
+					fmt.Fprintln(job.out, color.YellowString("\t[MISSING OR CHANGED] [%s]", e.Path(job.mainFolder)))
+				}
+				fd.validator = e.Validator
+			}
+			fd.postID = p.ID
+			fd.media = job.media
+			fd.page = page
+			fd.tracker = tracker
+			fd.post = pr
+			fd.postIndex = i
+			if tracker != nil {
+				tracker.add(page)
+			}
+			if pr != nil {
+				pr.queue()
+			}
+			job.queue.add(fd)
+		}
+
+		if pr != nil {
+			pr.finish(-1, "", false)
+		}
+	}
+}
+
+func (t *Tumblr) getPhotoFileJob(p *Post, job *tumblrJob) []*fileToDownload {
+	fl := []*fileToDownload{}
+
+	variants := [][]PhotoURL{p.PhotoURLs}
+	if len(p.PhotoSet.Photo) > 0 {
+		variants = variants[:0]
+		for _, psp := range p.PhotoSet.Photo {
+			variants = append(variants, psp.PhotoURL)
+		}
+	}
+
+	for _, pus := range variants {
+		if pu, ok := pickPhotoURL(pus, job.photoWidths); ok {
+			fd := &fileToDownload{url: pu.FileURL}
+			fd.destFile, fd.err = t.destination(job, p, pu.FileURL, len(fl)+1, pu.MaxWidth, "")
+			if job.photoOriginal {
+				fd.candidates = photoCandidates(pu.FileURL)
+			}
+			fl = append(fl, fd)
+		}
+	}
+
+	return fl
+}
+
+func (t *Tumblr) getVideoFileJob(p *Post, job *tumblrJob) []*fileToDownload {
+	fl := []*fileToDownload{}
+
+	for _, vp := range p.VideoPlayer {
+		// only direct video will be downloaded
+		if vp.MaxWidth == 0 && p.IsDirectVideo {
+			match := sourceRgx.FindStringSubmatch(vp.Content)
+			if len(match) == 2 {
+				videoURL := match[1]
+				// api v2 video url already contains extension
+				ext := ""
+				if p.VideoSource.Extension != "" {
+					ext = "." + p.VideoSource.Extension
+				}
+
+				fd := fileToDownload{url: videoURL}
+				fd.destFile, fd.err = t.destination(job, p, videoURL, len(fl)+1, p.VideoSource.Width, ext)
+				fl = append(fl, &fd)
+			}
+		}
+	}
+
+	return fl
+}
+
+func (t *Tumblr) getAudioFileJob(p *Post, job *tumblrJob) []*fileToDownload {
+	out := job.out
+	audioURL := p.AudioURL
+	if audioURL == "" {
+		// flash or iframe player pass hosted file as audio_file param, html5 player as <source src>
+		if match := audioFileRgx.FindStringSubmatch(p.AudioPlayer); len(match) == 2 {
+			audioURL, _ = url.QueryUnescape(match[1])
+		} else if match := sourceRgx.FindStringSubmatch(p.AudioPlayer); len(match) == 2 {
+			audioURL = match[1]
+		}
+	}
+
+	au, err := url.Parse(audioURL)
+	if audioURL == "" || err != nil {
+		// spotify, soundcloud etc. embed, only its host is logged
+		embed := "UNKNOWN EMBED"
+		if match := embedSrcRgx.FindStringSubmatch(p.AudioPlayer); len(match) == 2 {
+			if eu, eErr := url.Parse(match[1]); eErr == nil && eu.Host != "" {
+				embed = eu.Hostname()
+			}
+		}
+		fmt.Fprintln(out, color.YellowString("\t[SKIPPED] [%s] [NO HOSTED AUDIO FILE] [%s]", p.ID, embed))
+		return []*fileToDownload{}
+	}
+	if host := strings.ToLower(au.Hostname()); host != "tumblr.com" && !strings.HasSuffix(host, ".tumblr.com") {
+		fmt.Fprintln(out, color.YellowString("\t[SKIPPED] [%s] [THIRD PARTY AUDIO] [%s]", p.ID, host))
+		return []*fileToDownload{}
+	}
+
+	// tumblr audio_file url has no extension, it redirect into mp3 file
+	fd := &fileToDownload{url: audioURL}
+	fd.destFile, fd.err = t.destination(job, p, audioURL, 1, 0, ".mp3")
+
+	return []*fileToDownload{fd}
+}
@@ -0,0 +1,611 @@
+// Copyright (c) 2016 - Sarjono Mukti Aji <me@simukti.net>
+// Unless otherwise noted, this source code license is MIT-License
+
+package tumblr
+
+import (
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProcessMediaPagination(t *testing.T) {
+	f := newFakeTumblr()
+	defer f.Close()
+	f.addPosts("photo", photoPosts(1000, 1500000000, 5)...)
+	dest, clean := tempDest(t)
+	defer clean()
+
+	stats, _ := f.download(t, dest, Media(PHOTO))
+
+	if starts := f.pageStarts(); !reflect.DeepEqual(starts, []string{"0", "2", "4"}) {
+		t.Errorf("page starts = %v, want [0 2 4]", starts)
+	}
+	for i := 0; i < 5; i++ {
+		mustExist(t, photoFile(dest, 1000-i, 1500000000-i*86400))
+	}
+	if stats.Posts != 5 || stats.Files != 5 || stats.FailedPage != 0 || stats.FailedFile != 0 {
+		t.Errorf("stats = %+v", stats)
+	}
+
+	p, ok := readManifest(t, dest).Progress(PHOTO)
+	if !ok || !p.Complete || p.Page != 3 || p.Total != 3 {
+		t.Errorf("progress = %+v, %v", p, ok)
+	}
+}
+
+func TestProcessMediaLimitPage(t *testing.T) {
+	f := newFakeTumblr()
+	defer f.Close()
+	f.addPosts("photo", photoPosts(1000, 1500000000, 5)...)
+	dest, clean := tempDest(t)
+	defer clean()
+
+	stats, _ := f.download(t, dest, Media(PHOTO), LimitPage(2))
+
+	if starts := f.pageStarts(); !reflect.DeepEqual(starts, []string{"0", "2"}) {
+		t.Errorf("page starts = %v, want [0 2]", starts)
+	}
+	if stats.Files != 4 {
+		t.Errorf("files = %d, want 4", stats.Files)
+	}
+	mustNotExist(t, photoFile(dest, 996, 1500000000-4*86400))
+}
+
+func TestProcessMediaFailedPage(t *testing.T) {
+	f := newFakeTumblr()
+	defer f.Close()
+	f.addPosts("photo", photoPosts(1000, 1500000000, 4)...)
+	dest, clean := tempDest(t)
+	defer clean()
+
+	// first page request fail once and is retried
+	f.failPage = 1
+	stats, out := f.download(t, dest, Media(PHOTO))
+	if stats.FailedPage != 0 || stats.Files != 4 || !strings.Contains(out, "[RETRY 1/1]") {
+		t.Errorf("stats = %+v\n%s", stats, out)
+	}
+}
+
+func TestResumeFromPageProgress(t *testing.T) {
+	f := newFakeTumblr()
+	defer f.Close()
+	f.addPosts("photo", photoPosts(1000, 1500000000, 5)...)
+	dest, clean := tempDest(t)
+	defer clean()
+
+	if err := os.Mkdir(filepath.Join(dest, FAKEBLOG), 0700); err != nil {
+		t.Fatal(err)
+	}
+	m, err := OpenManifest(filepath.Join(dest, FAKEBLOG, MANIFESTFILE))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.SetProgress(PageProgress{Media: PHOTO, PerPage: 2, Page: 1, Total: 3})
+	m.Close()
+
+	stats, out := f.download(t, dest, Media(PHOTO))
+
+	if starts := f.pageStarts(); !reflect.DeepEqual(starts, []string{"2", "4"}) {
+		t.Errorf("page starts = %v, want [2 4]", starts)
+	}
+	if stats.Files != 3 || !strings.Contains(out, "RESUME FROM PAGE: 2") {
+		t.Errorf("stats = %+v\n%s", stats, out)
+	}
+}
+
+func TestManifestSkipDownloaded(t *testing.T) {
+	f := newFakeTumblr()
+	defer f.Close()
+	f.addPosts("photo", photoPosts(1000, 1500000000, 3)...)
+	dest, clean := tempDest(t)
+	defer clean()
+
+	f.download(t, dest, Media(PHOTO))
+	stats, _ := f.download(t, dest, Media(PHOTO))
+
+	if n := f.hitCount("/p/tumblr_1000_1280.jpg"); n != 1 {
+		t.Errorf("media requests = %d, want 1", n)
+	}
+	if stats.Files != 3 || stats.Downloaded != 0 {
+		t.Errorf("stats = %+v", stats)
+	}
+	e, ok := readManifest(t, dest).Lookup(FAKEMEDIAHOST + "/p/tumblr_1000_1280.jpg")
+	if !ok || e.Status != STATUSDONE || e.PostID != "1000" || e.Hash == "" ||
+		e.Size != int64(len(mediaBody("/p/tumblr_1000_1280.jpg"))) {
+		t.Errorf("manifest entry = %+v, %v", e, ok)
+	}
+}
+
+func TestManifestRedownloadBroken(t *testing.T) {
+	f := newFakeTumblr()
+	defer f.Close()
+	f.addPosts("photo", photoPosts(1000, 1500000000, 3)...)
+	dest, clean := tempDest(t)
+	defer clean()
+
+	f.download(t, dest, Media(PHOTO))
+	deleted := photoFile(dest, 1000, 1500000000)
+	cut := photoFile(dest, 999, 1500000000-86400)
+	if err := os.Remove(deleted); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(cut, 10); err != nil {
+		t.Fatal(err)
+	}
+
+	stats, out := f.download(t, dest, Media(PHOTO))
+
+	if !strings.Contains(out, "[MISSING OR CHANGED]") {
+		t.Errorf("broken files are not reported\n%s", out)
+	}
+	for _, path := range []string{"/p/tumblr_1000_1280.jpg", "/p/tumblr_999_1280.jpg", "/p/tumblr_998_1280.jpg"} {
+		want := 2
+		if path == "/p/tumblr_998_1280.jpg" {
+			want = 1
+		}
+		if n := f.hitCount(path); n != want {
+			t.Errorf("%s requests = %d, want %d", path, n, want)
+		}
+	}
+	for file, path := range map[string]string{deleted: "/p/tumblr_1000_1280.jpg", cut: "/p/tumblr_999_1280.jpg"} {
+		if got, err := ioutil.ReadFile(file); err != nil || string(got) != string(mediaBody(path)) {
+			t.Errorf("%s is not downloaded again: %v", file, err)
+		}
+	}
+	if stats.Files != 3 || stats.FailedFile != 0 {
+		t.Errorf("stats = %+v", stats)
+	}
+}
+
+func TestSinceLast(t *testing.T) {
+	f := newFakeTumblr()
+	defer f.Close()
+	f.addPosts("photo", photoPosts(1000, 1500000000, 5)...)
+	dest, clean := tempDest(t)
+	defer clean()
+
+	f.download(t, dest, Media(PHOTO), SinceLast(true))
+	s, ok := readManifest(t, dest).LastSync(PHOTO)
+	if !ok || s.PostID != "1000" {
+		t.Fatalf("sync mark = %+v, %v", s, ok)
+	}
+
+	f.prependPosts("photo", photoPosts(1002, 1500000000+2*86400, 2)...)
+	f.queries = nil
+	stats, out := f.download(t, dest, Media(PHOTO), SinceLast(true))
+
+	// second page contains last synced post, older pages are not fetched
+	if starts := f.pageStarts(); !reflect.DeepEqual(starts, []string{"0", "2"}) {
+		t.Errorf("page starts = %v, want [0 2]", starts)
+	}
+	if stats.Posts != 2 || stats.Files != 2 || !strings.Contains(out, "REACHED LAST SYNCED POST: 1000") {
+		t.Errorf("stats = %+v\n%s", stats, out)
+	}
+	if s, _ := readManifest(t, dest).LastSync(PHOTO); s.PostID != "1002" {
+		t.Errorf("sync mark = %+v, want 1002", s)
+	}
+}
+
+func TestSinceLastFailedFile(t *testing.T) {
+	f := newFakeTumblr()
+	defer f.Close()
+	f.addPosts("photo", photoPosts(1000, 1500000000, 3)...)
+	dest, clean := tempDest(t)
+	defer clean()
+
+	f.download(t, dest, Media(PHOTO), SinceLast(true))
+
+	// newest post can not be downloaded, sync mark must stay on last finished run
+	f.prependPosts("photo", photoPosts(1002, 1500000000+2*86400, 2)...)
+	f.handleMedia("/p/tumblr_1001_1280.jpg", http.NotFound)
+	stats, _ := f.download(t, dest, Media(PHOTO), SinceLast(true))
+	if stats.FailedFile != 1 {
+		t.Fatalf("stats = %+v", stats)
+	}
+	if s, _ := readManifest(t, dest).LastSync(PHOTO); s.PostID != "1000" {
+		t.Errorf("sync mark = %+v, want 1000", s)
+	}
+
+	// next sync retry it
+	f.handleMedia("/p/tumblr_1001_1280.jpg", serveMedia)
+	stats, _ = f.download(t, dest, Media(PHOTO), SinceLast(true))
+	if stats.FailedFile != 0 {
+		t.Errorf("stats = %+v", stats)
+	}
+	mustExist(t, photoFile(dest, 1001, 1500000000+86400))
+	if s, _ := readManifest(t, dest).LastSync(PHOTO); s.PostID != "1002" {
+		t.Errorf("sync mark = %+v, want 1002", s)
+	}
+}
+
+func TestFailedDocumentKeepSyncMark(t *testing.T) {
+	f := newFakeTumblr()
+	defer f.Close()
+	f.addPosts(TEXT, `<post id="40" unix-timestamp="1500000000" type="regular"><regular-title>t</regular-title></post>`)
+	dest, clean := tempDest(t)
+	defer clean()
+
+	// document path is taken by a folder, so it can not be written
+	if err := os.MkdirAll(filepath.Join(dest, FAKEBLOG, TEXT, "40_1500000000.html"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	stats, _ := f.download(t, dest, Media(TEXT), SinceLast(true))
+	if stats.FailedFile != 1 {
+		t.Fatalf("stats = %+v", stats)
+	}
+	if s, ok := readManifest(t, dest).LastSync(TEXT); ok {
+		t.Errorf("sync mark = %+v, want none", s)
+	}
+}
+
+func TestFilterDateRange(t *testing.T) {
+	f := newFakeTumblr()
+	defer f.Close()
+	f.addPosts("photo", photoPosts(1000, 1500000000, 8)...)
+	dest, clean := tempDest(t)
+	defer clean()
+
+	// posts 999, 998 and 997
+	after := time.Unix(1500000000-3*86400, 0)
+	before := time.Unix(1500000000-86400+1, 0)
+	stats, _ := f.download(t, dest, Media(PHOTO), After(after), Before(before))
+
+	// third page contains post older than window, last page is not fetched
+	if starts := f.pageStarts(); !reflect.DeepEqual(starts, []string{"0", "2", "4"}) {
+		t.Errorf("page starts = %v, want [0 2 4]", starts)
+	}
+	if stats.Posts != 3 {
+		t.Errorf("posts = %d, want 3", stats.Posts)
+	}
+	mustNotExist(t, photoFile(dest, 1000, 1500000000))
+	mustExist(t, photoFile(dest, 997, 1500000000-3*86400))
+
+	if _, ok := readManifest(t, dest).Progress(PHOTO); ok {
+		t.Errorf("filtered run must not record page progress")
+	}
+}
+
+func TestFilterTagsAndReblogs(t *testing.T) {
+	f := newFakeTumblr()
+	defer f.Close()
+	posts := photoPosts(1000, 1500000000, 4)
+	posts[0] = withTags(posts[0], "Art")
+	posts[1] = reblogged(withTags(posts[1], "art", "nsfw"))
+	posts[2] = reblogged(withTags(posts[2], "art"))
+	f.addPosts("photo", posts...)
+
+	tests := []struct {
+		name string
+		opts []Option
+		want []int
+	}{
+		{"tag", []Option{Tags("#art")}, []int{1000, 999, 998}},
+		{"exclude", []Option{Tags("art"), ExcludeTags("NSFW")}, []int{1000, 998}},
+		{"originals", []Option{Reblogs(REBLOGSNONE)}, []int{1000, 997}},
+		{"reblogs", []Option{Reblogs(REBLOGSONLY), ExcludeTags("nsfw")}, []int{998}},
+	}
+
+	for _, tt := range tests {
+		dest, clean := tempDest(t)
+		f.queries = nil
+		f.download(t, dest, append(tt.opts, Media(PHOTO))...)
+
+		got := []int{}
+		for i := 0; i < 4; i++ {
+			if _, err := os.Stat(photoFile(dest, 1000-i, 1500000000-i*86400)); err == nil {
+				got = append(got, 1000-i)
+			}
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: downloaded %v, want %v", tt.name, got, tt.want)
+		}
+		clean()
+	}
+
+	// single tag is passed to api
+	dest, clean := tempDest(t)
+	defer clean()
+	f.queries = nil
+	f.download(t, dest, Media(PHOTO), Tags("art"))
+	if tag := f.queries[0].Get("tagged"); tag != "art" {
+		t.Errorf("tagged = %q, want art", tag)
+	}
+}
+
+func TestPostIDsDateRange(t *testing.T) {
+	f := newFakeTumblr()
+	defer f.Close()
+	f.addPosts(PHOTO, photoPosts(1000, 1500000000, 3)...)
+	dest, clean := tempDest(t)
+	defer clean()
+
+	// only post 999 is inside window
+	after := time.Unix(1500000000-86400, 0)
+	before := time.Unix(1500000000, 0)
+	stats, out := f.download(t, dest, PostIDs("1000", "999", "998"), After(after), Before(before))
+
+	if stats.Files != 1 || strings.Count(out, "[OUTSIDE DATE RANGE]") != 2 {
+		t.Errorf("stats = %+v\n%s", stats, out)
+	}
+	mustNotExist(t, photoFile(dest, 1000, 1500000000))
+	mustExist(t, photoFile(dest, 999, 1500000000-86400))
+	mustNotExist(t, photoFile(dest, 998, 1500000000-2*86400))
+}
+
+// pageOf decode legacy api page of given posts
+func pageOf(t *testing.T, posts ...string) *Tumblr {
+	t.Helper()
+
+	page := &Tumblr{}
+	data := `<tumblr><tumblelog name="fake" timezone="UTC"></tumblelog><posts start="0" total="1">` +
+		strings.Join(posts, "") + `</posts></tumblr>`
+	if err := xml.Unmarshal([]byte(data), page); err != nil {
+		t.Fatal(err)
+	}
+
+	return page
+}
+
+// extractJob minimal job needed to build file list of a page
+func extractJob(media, layout string) *tumblrJob {
+	return &tumblrJob{
+		username:   FAKEBLOG,
+		mainFolder: "/dest",
+		media:      media,
+		layout:     layout,
+		out:        ioutil.Discard,
+		zones:      map[string]*time.Location{},
+	}
+}
+
+func TestGetPhotoFileJob(t *testing.T) {
+	page := pageOf(t,
+		photoPost("10", 1500000000, "tumblr_a"),
+		photoPost("11", 1500000000, "tumblr_b", "tumblr_c", "tumblr_d"),
+	)
+	job := extractJob(PHOTO, "{blog}/{id}_{index}_{width}{ext}")
+
+	single := page.getPhotoFileJob(&page.Posts.Posts[0], job)
+	if len(single) != 1 || single[0].url != FAKEMEDIAHOST+"/p/tumblr_a_1280.jpg" ||
+		single[0].destFile != filepath.FromSlash("/dest/fake/10_1_1280.jpg") {
+		t.Errorf("single photo = %+v", single)
+	}
+
+	// photoset replace main photo, each photo keep its position
+	set := page.getPhotoFileJob(&page.Posts.Posts[1], job)
+	if len(set) != 3 {
+		t.Fatalf("photoset files = %d, want 3", len(set))
+	}
+	for i, name := range []string{"tumblr_b", "tumblr_c", "tumblr_d"} {
+		if set[i].url != FAKEMEDIAHOST+"/p/"+name+"_1280.jpg" {
+			t.Errorf("photoset url %d = %s", i, set[i].url)
+		}
+	}
+	if set[2].destFile != filepath.FromSlash("/dest/fake/11_3_1280.jpg") {
+		t.Errorf("photoset dest = %s", set[2].destFile)
+	}
+
+	// max width policy
+	job.photoWidths = []int{500}
+	if small := page.getPhotoFileJob(&page.Posts.Posts[0], job); small[0].url != FAKEMEDIAHOST+"/p/tumblr_a_500.jpg" {
+		t.Errorf("max width 500 url = %s", small[0].url)
+	}
+}
+
+func TestGetVideoFileJob(t *testing.T) {
+	page := pageOf(t,
+		videoPost("20", 1500000000, "tumblr_v"),
+		embedPost("21", 1500000000, "https://www.youtube.com/embed/dQw4w9WgXcQ"),
+	)
+	job := extractJob(VIDEO, DEFAULTLAYOUT)
+
+	direct := page.getVideoFileJob(&page.Posts.Posts[0], job)
+	if len(direct) != 1 || direct[0].url != FAKEMEDIAHOST+"/video_file/tumblr_v" ||
+		direct[0].destFile != filepath.FromSlash("/dest/fake/video/20_1500000000_tumblr_v.mp4") {
+		t.Errorf("direct video = %+v", direct)
+	}
+
+	if embed := page.getVideoFileJob(&page.Posts.Posts[1], job); len(embed) != 0 {
+		t.Errorf("embedded video must not be downloaded, got %+v", embed)
+	}
+}
+
+func TestDownloadPhotosetAndVideo(t *testing.T) {
+	f := newFakeTumblr()
+	defer f.Close()
+	f.addPosts("photo", photoPost("30", 1500000000, "tumblr_s1", "tumblr_s2"))
+	f.addPosts("video",
+		videoPost("31", 1500000000, "tumblr_v31"),
+		embedPost("32", 1499990000, "https://www.youtube.com/embed/dQw4w9WgXcQ"),
+	)
+	dest, clean := tempDest(t)
+	defer clean()
+
+	stats, _ := f.download(t, dest, Media("photo,video"))
+
+	mustExist(t, filepath.Join(dest, FAKEBLOG, PHOTO, "30_1500000000_tumblr_s1_1280.jpg"))
+	mustExist(t, filepath.Join(dest, FAKEBLOG, PHOTO, "30_1500000000_tumblr_s2_1280.jpg"))
+	mustExist(t, filepath.Join(dest, FAKEBLOG, VIDEO, "31_1500000000_tumblr_v31.mp4"))
+	if stats.Files != 3 {
+		t.Errorf("files = %d, want 3", stats.Files)
+	}
+
+	embeds, err := ioutil.ReadFile(filepath.Join(dest, FAKEBLOG, EMBEDFILE))
+	if err != nil || !strings.Contains(string(embeds), `"url":"https://www.youtube.com/watch?v=dQw4w9WgXcQ"`) {
+		t.Errorf("embed list = %s, %v", embeds, err)
+	}
+}
+
+func TestCheckLayout(t *testing.T) {
+	tests := map[string]bool{
+		DEFAULTLAYOUT:                  true,
+		"{media}/{id}{ext}":            false,
+		"{blog}/{id}_{index}{ext}":     true,
+		"{blog}/{year}/{name}{ext}":    true,
+		"{blog}/{timestamp}{ext}":      false,
+		"../{blog}/{name}{ext}":        false,
+		"/tmp/{name}{ext}":             false,
+		"{blog}/{unknown}_{name}{ext}": false,
+	}
+
+	for layout, ok := range tests {
+		if err := checkLayout(layout); (err == nil) != ok {
+			t.Errorf("checkLayout(%s) = %v, want ok %v", layout, err, ok)
+		}
+	}
+}
+
+func TestLayoutStayInsideDestination(t *testing.T) {
+	page := pageOf(t,
+		withTags(photoPost("10", 1500000000, "tumblr_a"), ".."),
+		withTags(photoPost("11", 1500000000, "tumblr_b"), ".hidden"),
+	)
+	job := extractJob(PHOTO, "{tag}/{id}_{index}{ext}")
+
+	// tag is set by blog author, dot segment must not leave destination
+	if fl := page.getPhotoFileJob(&page.Posts.Posts[0], job); fl[0].err != nil ||
+		fl[0].destFile != filepath.FromSlash("/dest/-/10_1.jpg") {
+		t.Errorf("dot dot tag = %s, %v", fl[0].destFile, fl[0].err)
+	}
+	if fl := page.getPhotoFileJob(&page.Posts.Posts[1], job); fl[0].destFile != filepath.FromSlash("/dest/-hidden/11_1.jpg") {
+		t.Errorf("hidden tag = %s", fl[0].destFile)
+	}
+
+	// anything which still end up outside is refused
+	job.layout = "../{id}_{index}{ext}"
+	if fl := page.getPhotoFileJob(&page.Posts.Posts[0], job); fl[0].err == nil {
+		t.Errorf("outside destination = %s, want error", fl[0].destFile)
+	}
+}
+
+func TestLayoutDotTagDownload(t *testing.T) {
+	f := newFakeTumblr()
+	defer f.Close()
+	f.addPosts(PHOTO, withTags(photoPost("60", 1500000000, "tumblr_a"), ".."))
+	dest, clean := tempDest(t)
+	defer clean()
+	inner := filepath.Join(dest, "inner")
+	if err := os.Mkdir(inner, 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	stats, _ := f.download(t, inner, Media(PHOTO), Layout("{tag}/{id}_{index}{ext}"))
+
+	if stats.FailedFile != 0 || stats.Files != 1 {
+		t.Errorf("stats = %+v", stats)
+	}
+	mustExist(t, filepath.Join(inner, "-", "60_1.jpg"))
+	mustNotExist(t, filepath.Join(dest, "60_1.jpg"))
+}
+
+func TestLayoutDocumentAndSidecar(t *testing.T) {
+	f := newFakeTumblr()
+	defer f.Close()
+	f.addPosts(PHOTO, photoPost("50", 1500000000, "tumblr_s1", "tumblr_s2"))
+	f.addPosts(TEXT, `<post id="51" unix-timestamp="1500000000" type="regular"><regular-title>t</regular-title></post>`)
+	dest, clean := tempDest(t)
+	defer clean()
+
+	f.download(t, dest, Media("photo,text"), Metadata(METAPOST), Layout("{media}/{year}/{id}_{index}{ext}"))
+
+	mustExist(t, filepath.Join(dest, PHOTO, "2017", "50_1.jpg"))
+	mustExist(t, filepath.Join(dest, PHOTO, "2017", "50_2.jpg"))
+	mustExist(t, filepath.Join(dest, PHOTO, "2017", "50_1500000000.json"))
+	mustExist(t, filepath.Join(dest, TEXT, "2017", "51_1500000000.html"))
+	mustExist(t, filepath.Join(dest, TEXT, "2017", "51_1500000000.json"))
+	mustNotExist(t, filepath.Join(dest, FAKEBLOG, PHOTO))
+	mustNotExist(t, filepath.Join(dest, FAKEBLOG, TEXT))
+}
+
+func TestFolder(t *testing.T) {
+	dest, clean := tempDest(t)
+	defer clean()
+	other, cleanOther := tempDest(t)
+	defer cleanOther()
+
+	d, err := New(Destination(dest))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if folder, name, err := d.Folder("https://Staff.tumblr.com/"); err != nil || folder != dest || name != "staff" {
+		t.Errorf("Folder = %s, %s, %v, want %s, staff", folder, name, err, dest)
+	}
+	if folder, name, err := d.Folder("staff", Destination(other)); err != nil || folder != other || name != "staff" {
+		t.Errorf("Folder with dest = %s, %s, %v, want %s, staff", folder, name, err, other)
+	}
+}
+
+func TestCustomDomainResolved(t *testing.T) {
+	f := newFakeTumblr()
+	defer f.Close()
+	f.addPosts(PHOTO, photoPosts(1000, 1500000000, 1)...)
+	dest, clean := tempDest(t)
+	defer clean()
+
+	d, err := New(Destination(dest), BaseURL(f.URL+"/%s"), HTTPClient(f.Client()), Output(ioutil.Discard))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if folder, name, err := d.Folder("www.fakeblog.net"); err != nil || folder != dest || name != FAKEBLOG {
+		t.Errorf("Folder = %s, %s, %v, want %s, %s", folder, name, err, dest, FAKEBLOG)
+	}
+
+	// blog is stored in folder of its resolved name
+	if err := d.Download("www.fakeblog.net", Media(PHOTO), MediaHost(f.URL)); err != nil {
+		t.Fatalf("Download: %v", err)
+	}
+	mustExist(t, photoFile(dest, 1000, 1500000000))
+}
+
+func TestEmbedCommandOnlyEmbeds(t *testing.T) {
+	f := newFakeTumblr()
+	defer f.Close()
+	f.addPosts(VIDEO, embedPost("70", 1500000000, "https://www.youtube.com/embed/dQw4w9WgXcQ"))
+	dest, clean := tempDest(t)
+	defer clean()
+
+	// blog without any downloaded video has no video folder yet
+	_, out := f.download(t, dest, Media(VIDEO), EmbedCommand("true"))
+
+	if strings.Contains(out, "[ERROR EMBED COMMAND]") {
+		t.Errorf("embed command failed\n%s", out)
+	}
+	embeds, err := ioutil.ReadFile(filepath.Join(dest, FAKEBLOG, EMBEDFILE))
+	if err != nil || !strings.Contains(string(embeds), `"command":"`+STATUSDONE+`"`) {
+		t.Errorf("embed list = %s, %v", embeds, err)
+	}
+}
+
+func TestEmbedCommandLayoutAndFailure(t *testing.T) {
+	f := newFakeTumblr()
+	defer f.Close()
+	f.addPosts(VIDEO, embedPost("71", 1500000000, "https://www.youtube.com/embed/dQw4w9WgXcQ"))
+	dest, clean := tempDest(t)
+	defer clean()
+
+	// command write its working folder into a file there
+	stats, out := f.download(t, dest, Media(VIDEO), SinceLast(true),
+		Layout("{blog}-{media}/{year}/{id}_{index}{ext}"), EmbedCommand("sh -c pwd>cwd.txt"))
+	if stats.FailedEmbed != 0 {
+		t.Fatalf("stats = %+v\n%s", stats, out)
+	}
+	mustExist(t, filepath.Join(dest, FAKEBLOG+"-"+VIDEO, "2017", "cwd.txt"))
+
+	f.prependPosts(VIDEO, embedPost("72", 1500000100, "https://vimeo.com/76979871"))
+	stats, out = f.download(t, dest, Media(VIDEO), SinceLast(true), EmbedCommand("false"))
+	if stats.FailedEmbed != 1 || !strings.Contains(out, "[ERROR EMBED COMMAND]") {
+		t.Errorf("stats = %+v\n%s", stats, out)
+	}
+	// failed command keep sync mark, so the post is seen again on next sync
+	if s, ok := readManifest(t, dest).LastSync(VIDEO); !ok || s.PostID != "71" {
+		t.Errorf("sync mark = %+v, %v, want post 71", s, ok)
+	}
+}
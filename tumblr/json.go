@@ -0,0 +1,313 @@
+// Copyright (c) 2016 - Sarjono Mukti Aji <me@simukti.net>
+// Unless otherwise noted, this source code license is MIT-License
+
+package tumblr
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+)
+
+const (
+	// JSONAPIURL tumblr api v2 posts path, blog identifier (name.tumblr.com or custom domain) and post type
+	JSONAPIURL = "https://api.tumblr.com/v2/blog/%s/posts/%s"
+
+	// JSONMAXLIMIT maximum posts returned by single api v2 request
+	JSONMAXLIMIT = 20
+)
+
+// JSONBackend tumblr api v2 /v2/blog/{blog}/posts, it need registered application api key
+type JSONBackend struct {
+	APIKey  string
+	BaseURL string // posts url format with blog and post type verbs, default is JSONAPIURL
+}
+
+// jsonResponse api v2 envelope
+type jsonResponse struct {
+	Meta struct {
+		Status int    `json:"status"`
+		Msg    string `json:"msg"`
+	} `json:"meta"`
+	Response struct {
+		Blog struct {
+			Name string `json:"name"`
+			URL  string `json:"url"`
+		} `json:"blog"`
+		Posts      []jsonPost `json:"posts"`
+		TotalPosts int        `json:"total_posts"`
+	} `json:"response"`
+}
+
+type jsonPost struct {
+	ID        json.Number `json:"id"`
+	Type      string      `json:"type"`
+	Timestamp int         `json:"timestamp"`
+	Slug      string      `json:"slug"`
+	PostURL   string      `json:"post_url"`
+	Tags      []string    `json:"tags"`
+	Caption   string      `json:"caption"`
+	Photos    []struct {
+		Caption      string          `json:"caption"`
+		OriginalSize jsonPhotoSize   `json:"original_size"`
+		AltSizes     []jsonPhotoSize `json:"alt_sizes"`
+	} `json:"photos"`
+	VideoType string          `json:"video_type"`
+	VideoURL  string          `json:"video_url"`
+	Permalink string          `json:"permalink_url"`
+	Duration  int             `json:"duration"`
+	Player    json.RawMessage `json:"player"` // list of jsonPlayer on video post, embed code on audio post
+
+	Title       string `json:"title"`
+	Body        string `json:"body"`
+	Text        string `json:"text"`
+	Source      string `json:"source"`
+	URL         string `json:"url"`
+	Description string `json:"description"`
+	Dialogue    []struct {
+		Name   string `json:"name"`
+		Label  string `json:"label"`
+		Phrase string `json:"phrase"`
+	} `json:"dialogue"`
+	Question  string `json:"question"`
+	Answer    string `json:"answer"`
+	Artist    string `json:"artist"`
+	Album     string `json:"album"`
+	TrackName string `json:"track_name"`
+	AudioType string `json:"audio_type"`
+	AudioURL  string `json:"audio_url"`
+
+	// only returned with reblog_info=true
+	RebloggedFromName string `json:"reblogged_from_name"`
+	RebloggedFromURL  string `json:"reblogged_from_url"`
+	RebloggedRootName string `json:"reblogged_root_name"`
+	RebloggedRootURL  string `json:"reblogged_root_url"`
+}
+
+type jsonPlayer struct {
+	Width     interface{} `json:"width"`      // number, or string like "100%"
+	EmbedCode interface{} `json:"embed_code"` // false when video is not available
+}
+
+// api v2 post type name which differ from legacy api
+var jsonPostTypes = map[string]string{TEXT: postTypes[TEXT], CHAT: postTypes[CHAT]}
+
+type jsonPhotoSize struct {
+	URL    string `json:"url"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+}
+
+// Posts implement Backend, page bigger than JSONMAXLIMIT is fetched in several requests
+func (b JSONBackend) Posts(client *http.Client, q Query) (*Tumblr, error) {
+	if b.APIKey == "" {
+		return &Tumblr{}, errors.New("Tumblr api v2 require api key")
+	}
+
+	t := &Tumblr{}
+	offset := q.Start
+	remain := q.Num
+
+	for {
+		limit := remain
+		if limit > JSONMAXLIMIT {
+			limit = JSONMAXLIMIT
+		}
+		if limit < 1 {
+			// only blog detail and total posts needed
+			limit = 1
+		}
+
+		page, err := b.fetch(client, q, offset, limit)
+		if err != nil {
+			return t, err
+		}
+
+		t.TumbleBlog.Name = page.Response.Blog.Name
+		t.Posts.Type = q.Media
+		t.Posts.Start = q.Start
+		t.Posts.Total = page.Response.TotalPosts
+		if q.Num < 1 {
+			return t, nil
+		}
+
+		for _, jp := range page.Response.Posts {
+			t.Posts.Posts = append(t.Posts.Posts, jp.toPost())
+		}
+
+		got := len(page.Response.Posts)
+		remain -= got
+		offset += got
+		if remain < 1 || got < limit {
+			return t, nil
+		}
+	}
+}
+
+func (b JSONBackend) fetch(client *http.Client, q Query, offset, limit int) (*jsonResponse, error) {
+	r := &jsonResponse{}
+	blog, media := blogIdentifier(q.Blog), q.Media
+	params := url.Values{}
+	params.Set("api_key", b.APIKey)
+	params.Set("offset", strconv.Itoa(offset))
+	params.Set("limit", strconv.Itoa(limit))
+	params.Set("reblog_info", "true")
+	if q.Tag != "" {
+		params.Set("tag", q.Tag)
+	}
+	if q.ID != "" {
+		params.Set("id", q.ID)
+	}
+	base := b.BaseURL
+	if base == "" {
+		base = JSONAPIURL
+	}
+	// single post is fetched without post type
+	api := strings.TrimSuffix(fmt.Sprintf(base, blog, media), "/") + "?" + params.Encode()
+
+	useragent := defaultUserAgents[rand.Intn(len(defaultUserAgents))]
+	apiReq, _ := http.NewRequest("GET", api, nil)
+	apiReq.Header.Set("User-Agent", useragent)
+	apiResp, apiErr := client.Do(apiReq)
+	if apiErr != nil {
+		return r, apiErr
+	}
+	defer apiResp.Body.Close()
+
+	if apiResp.StatusCode != http.StatusOK {
+		// api key is not part of error message
+		return r, newStatusError(apiResp, fmt.Sprintf(base, blog, media))
+	}
+
+	if err := json.NewDecoder(apiResp.Body).Decode(r); err != nil {
+		return r, err
+	}
+
+	if r.Meta.Status != http.StatusOK {
+		return r, fmt.Errorf("[%d] [%s]", r.Meta.Status, r.Meta.Msg)
+	}
+
+	return r, nil
+}
+
+// toPost map api v2 post into legacy Post model
+func (jp *jsonPost) toPost() Post {
+	p := Post{
+		ID:        jp.ID.String(),
+		Timestamp: jp.Timestamp,
+		Type:      jp.Type,
+		Slug:      jp.Slug,
+		URL:       jp.PostURL,
+		Tags:      jp.Tags,
+
+		RebloggedFromName: jp.RebloggedFromName,
+		RebloggedFromURL:  jp.RebloggedFromURL,
+		RebloggedRootName: jp.RebloggedRootName,
+		RebloggedRootURL:  jp.RebloggedRootURL,
+	}
+
+	if t, ok := jsonPostTypes[jp.Type]; ok {
+		p.Type = t
+	}
+
+	switch jp.Type {
+	case PHOTO:
+		p.PhotoCaption = jp.Caption
+	case VIDEO:
+		p.VideoCaption = jp.Caption
+	case TEXT:
+		p.RegularTitle = jp.Title
+		p.RegularBody = jp.Body
+	case QUOTE:
+		p.QuoteText = jp.Text
+		p.QuoteSource = jp.Source
+	case LINK:
+		p.LinkText = jp.Title
+		p.LinkURL = jp.URL
+		p.LinkDescription = jp.Description
+	case CHAT:
+		p.ConversationTitle = jp.Title
+		p.ConversationText = jp.Body
+		for _, d := range jp.Dialogue {
+			p.Conversation = append(p.Conversation, ConversationLine{Name: d.Name, Label: d.Label, Text: d.Phrase})
+		}
+	case ANSWER:
+		p.Question = jp.Question
+		p.Answer = jp.Answer
+	case AUDIO:
+		p.AudioCaption = jp.Caption
+		p.ID3Artist = jp.Artist
+		p.ID3Album = jp.Album
+		p.ID3Title = jp.TrackName
+		_ = json.Unmarshal(jp.Player, &p.AudioPlayer)
+		if jp.AudioType == "tumblr" {
+			p.AudioURL = jp.AudioURL
+		}
+	}
+
+	if len(jp.Photos) == 1 {
+		p.PhotoWidth = jp.Photos[0].OriginalSize.Width
+		p.PhotoHeight = jp.Photos[0].OriginalSize.Height
+		p.PhotoURLs = jsonPhotoURLs(jp.Photos[0].OriginalSize, jp.Photos[0].AltSizes)
+	} else {
+		for i, ph := range jp.Photos {
+			p.PhotoSet.Photo = append(p.PhotoSet.Photo, Photo{
+				Offset:   fmt.Sprintf("o%d", i+1),
+				Caption:  ph.Caption,
+				Width:    ph.OriginalSize.Width,
+				Height:   ph.OriginalSize.Height,
+				PhotoURL: jsonPhotoURLs(ph.OriginalSize, ph.AltSizes),
+			})
+		}
+	}
+
+	if jp.VideoType == "tumblr" && jp.VideoURL != "" {
+		p.IsDirectVideo = true
+		p.VideoSource.Extension = strings.TrimPrefix(path.Ext(jp.VideoURL), ".")
+		p.VideoSource.Duration = jp.Duration
+		p.VideoPlayer = append(p.VideoPlayer, VideoPlayer{
+			Content: fmt.Sprintf(`<video><source src="%s"></video>`, jp.VideoURL),
+		})
+	}
+
+	if jp.VideoType != "tumblr" {
+		p.EmbedURL = jp.Permalink
+	}
+
+	players := []jsonPlayer{}
+	if jp.Type == VIDEO {
+		_ = json.Unmarshal(jp.Player, &players)
+	}
+	for _, pl := range players {
+		code, ok := pl.EmbedCode.(string)
+		if !ok {
+			continue
+		}
+		width, _ := pl.Width.(float64)
+		p.VideoPlayer = append(p.VideoPlayer, VideoPlayer{MaxWidth: int(width), Content: code})
+	}
+
+	return p
+}
+
+// jsonPhotoURLs every alt size as PhotoURL, original size only if its width is not listed yet
+func jsonPhotoURLs(original jsonPhotoSize, alt []jsonPhotoSize) []PhotoURL {
+	pus := []PhotoURL{}
+	widths := map[int]bool{}
+	for _, s := range alt {
+		widths[s.Width] = true
+		pus = append(pus, PhotoURL{MaxWidth: s.Width, FileURL: s.URL})
+	}
+
+	if original.URL != "" && !widths[original.Width] {
+		pus = append(pus, PhotoURL{MaxWidth: original.Width, FileURL: original.URL})
+	}
+
+	return pus
+}
@@ -0,0 +1,123 @@
+// Copyright (c) 2016 - Sarjono Mukti Aji <me@simukti.net>
+// Unless otherwise noted, this source code license is MIT-License
+
+package tumblr
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestJSONBackendDownload(t *testing.T) {
+	f := newFakeTumblr()
+	defer f.Close()
+	for i := 0; i < 30; i++ {
+		id := strconv.Itoa(1000 - i)
+		f.addJSONPosts(PHOTO, jsonPhotoPost(id, 1500000000-i*86400, "tumblr_"+id))
+	}
+	f.addJSONPosts(VIDEO,
+		jsonVideoPost("41", 1500000000, "tumblr_v41"),
+		jsonEmbedPost("42", 1499990000, YOUTUBE, "https://www.youtube.com/watch?v=dQw4w9WgXcQ",
+			"https://www.youtube.com/embed/dQw4w9WgXcQ"),
+	)
+	f.addJSONPosts(TEXT, `{"id":50,"type":"text","timestamp":1500000000,"title":"json title","body":"<p>json body</p>"}`)
+	f.addJSONPosts(CHAT, `{"id":51,"type":"chat","timestamp":1500000000,"title":"chat title",`+
+		`"dialogue":[{"name":"alice","label":"alice:","phrase":"hello bob"}]}`)
+	dest, clean := tempDest(t)
+	defer clean()
+
+	stats, out := f.download(t, dest, f.jsonAPI(), Media("photo,video,text,chat"), PerPage(25))
+
+	// page bigger than JSONMAXLIMIT is fetched in several requests, total is fetched with limit 1
+	photoPages := []string{}
+	for _, q := range f.queries {
+		if q.Get("api_key") != "key" || q.Get("reblog_info") != "true" {
+			t.Errorf("query = %v", q)
+		}
+		if q.Get("offset") != "" && len(photoPages) < 4 {
+			photoPages = append(photoPages, q.Get("offset")+"/"+q.Get("limit"))
+		}
+	}
+	if want := []string{"0/1", "0/20", "20/5", "25/20"}; !reflect.DeepEqual(photoPages, want) {
+		t.Errorf("photo requests = %v, want %v", photoPages, want)
+	}
+	for i := 0; i < 30; i++ {
+		mustExist(t, photoFile(dest, 1000-i, 1500000000-i*86400))
+	}
+
+	// direct video is downloaded, embedded one is listed
+	mustExist(t, filepath.Join(dest, FAKEBLOG, VIDEO, "41_1500000000_tumblr_v41.mp4"))
+	embeds, err := ioutil.ReadFile(filepath.Join(dest, FAKEBLOG, EMBEDFILE))
+	if err != nil || !strings.Contains(string(embeds), `"post_id":"42"`) ||
+		!strings.Contains(string(embeds), `"url":"https://www.youtube.com/watch?v=dQw4w9WgXcQ"`) {
+		t.Errorf("embed list = %s, %v", embeds, err)
+	}
+
+	// text and chat are api v2 name of regular and conversation post
+	for file, want := range map[string]string{
+		filepath.Join(TEXT, "50_1500000000.html"): "json body",
+		filepath.Join(CHAT, "51_1500000000.html"): "hello bob",
+	} {
+		b, err := ioutil.ReadFile(filepath.Join(dest, FAKEBLOG, file))
+		if err != nil || !strings.Contains(string(b), want) {
+			t.Errorf("document %s = %s, %v", file, b, err)
+		}
+	}
+
+	if stats.Posts != 34 || stats.Files != 33 || stats.FailedPage != 0 || stats.FailedFile != 0 {
+		t.Errorf("stats = %+v\n%s", stats, out)
+	}
+}
+
+func TestJSONBackendPhotoSize(t *testing.T) {
+	f := newFakeTumblr()
+	defer f.Close()
+	f.addJSONPosts(PHOTO, jsonPhotoPost("60", 1500000000, "tumblr_a", "tumblr_b"))
+	dest, clean := tempDest(t)
+	defer clean()
+
+	// alt sizes are photo variants, 1280 original is listed once
+	page, err := JSONBackend{APIKey: "key", BaseURL: f.URL + "/v2/blog/%s/posts/%s"}.
+		Posts(f.Client(), Query{Blog: FAKEBLOG, Media: PHOTO, Num: 1})
+	if err != nil || len(page.Posts.Posts) != 1 {
+		t.Fatalf("posts = %+v, %v", page, err)
+	}
+	set := page.Posts.Posts[0].PhotoSet.Photo
+	if len(set) != 2 || set[1].Offset != "o2" || len(set[1].PhotoURL) != 3 ||
+		set[1].PhotoURL[1] != (PhotoURL{MaxWidth: 500, FileURL: FAKEMEDIAHOST + "/p/tumblr_b_500.jpg"}) {
+		t.Errorf("photoset = %+v", set)
+	}
+
+	f.download(t, dest, f.jsonAPI(), Media(PHOTO), PhotoSize("500"))
+	for _, name := range []string{"tumblr_a", "tumblr_b"} {
+		mustExist(t, filepath.Join(dest, FAKEBLOG, PHOTO, fmt.Sprintf("60_1500000000_%s_500.jpg", name)))
+		mustNotExist(t, filepath.Join(dest, FAKEBLOG, PHOTO, fmt.Sprintf("60_1500000000_%s_1280.jpg", name)))
+	}
+}
+
+func TestJSONBackendStatusError(t *testing.T) {
+	f := newFakeTumblr()
+	defer f.Close()
+	f.addJSONPosts(PHOTO, jsonPhotoPost("70", 1500000000, "tumblr_70"))
+	f.jsonStatus = http.StatusForbidden
+	dest, clean := tempDest(t)
+	defer clean()
+
+	stats, out := f.download(t, dest, f.jsonAPI(), Media(PHOTO))
+
+	if stats.FailedPage != 1 || stats.Files != 0 || !strings.Contains(out, "[403] [Forbidden]") {
+		t.Errorf("stats = %+v\n%s", stats, out)
+	}
+	mustNotExist(t, photoFile(dest, 70, 1500000000))
+
+	// api key is required before any request
+	if _, err := (JSONBackend{}).Posts(f.Client(), Query{Blog: FAKEBLOG, Media: PHOTO, Num: 1}); err == nil {
+		t.Errorf("posts without api key error = nil")
+	}
+}
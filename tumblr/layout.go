@@ -0,0 +1,159 @@
+// Copyright (c) 2016 - Sarjono Mukti Aji <me@simukti.net>
+// Unless otherwise noted, this source code license is MIT-License
+
+package tumblr
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// DEFAULTLAYOUT default media file layout, relative to main destination folder
+	DEFAULTLAYOUT = "{blog}/{media}/{id}_{timestamp}_{name}{ext}"
+)
+
+var (
+	layoutFieldRgx = regexp.MustCompile(`\{(\w+)\}`)
+
+	// layoutFields every template field, date fields use blog timezone
+	layoutFields = map[string]bool{
+		"blog": true, "media": true, "id": true, "timestamp": true, "slug": true,
+		"tags": true, "tag": true, "index": true, "width": true, "name": true, "ext": true,
+		"year": true, "month": true, "day": true, "date": true, "time": true,
+	}
+
+	// characters which are not allowed in file name on common filesystems
+	unsafeNameRgx = regexp.MustCompile(`[/\\:*?"<>|\x00-\x1f]+`)
+)
+
+// checkLayout validate layout template, it must be relative, can not leave destination folder
+// and must give every file of a post its own name
+func checkLayout(layout string) error {
+	if strings.TrimSpace(layout) == "" {
+		return fmt.Errorf("Layout must not be empty")
+	}
+
+	used := map[string]bool{}
+	for _, m := range layoutFieldRgx.FindAllStringSubmatch(layout, -1) {
+		if !layoutFields[m[1]] {
+			return fmt.Errorf("Unknown layout field {%s}", m[1])
+		}
+		used[m[1]] = true
+	}
+	// photoset files share every post field, they would overwrite each other
+	if !used["name"] && !(used["id"] && used["index"]) {
+		return fmt.Errorf("Layout %s must contain {name} or both {id} and {index}", layout)
+	}
+
+	if path.IsAbs(layout) || filepath.IsAbs(layout) {
+		return fmt.Errorf("Layout %s must be relative to destination folder", layout)
+	}
+	for _, seg := range strings.Split(filepath.ToSlash(layout), "/") {
+		if seg == ".." {
+			return fmt.Errorf("Layout %s must not contain '..'", layout)
+		}
+	}
+
+	return nil
+}
+
+// destination full path of single media file, index is 1-based position in post
+// and ext is used when file url has none
+func (t *Tumblr) destination(job *tumblrJob, p *Post, fileURL string, index, width int, ext string) (string, error) {
+	name := ""
+	if fu, err := url.Parse(fileURL); err == nil {
+		name = path.Base(fu.Path)
+	}
+	if e := path.Ext(name); e != "" {
+		ext = e
+		name = strings.TrimSuffix(name, e)
+	}
+
+	return t.layoutPath(job, p, name, index, width, ext)
+}
+
+// postFile full path of file which belong to whole post (document or metadata sidecar),
+// it is named <id>_<timestamp><ext> inside folder of first media file of the post
+func (t *Tumblr) postFile(job *tumblrJob, p *Post, ext string) (string, error) {
+	name := fmt.Sprintf("%s_%d", p.ID, p.Timestamp)
+	first, err := t.layoutPath(job, p, name, 1, 0, ext)
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(filepath.Dir(first), name+ext), nil
+}
+
+// layoutPath fill layout template of single file, file which would end up outside
+// of main destination folder is refused
+func (t *Tumblr) layoutPath(job *tumblrJob, p *Post, name string, index, width int, ext string) (string, error) {
+	date := time.Unix(int64(p.Timestamp), 0).In(job.location(t.TumbleBlog.Timezone))
+	tag := ""
+	if len(p.Tags) > 0 {
+		tag = p.Tags[0]
+	}
+
+	fields := map[string]string{
+		"blog":      t.TumbleBlog.Name,
+		"media":     job.media,
+		"id":        p.ID,
+		"timestamp": strconv.Itoa(p.Timestamp),
+		"slug":      p.Slug,
+		"tags":      strings.Join(p.Tags, ","),
+		"tag":       tag,
+		"index":     strconv.Itoa(index),
+		"width":     strconv.Itoa(width),
+		"name":      name,
+		"ext":       ext,
+		"year":      date.Format("2006"),
+		"month":     date.Format("01"),
+		"day":       date.Format("02"),
+		"date":      date.Format("2006-01-02"),
+		"time":      date.Format("150405"),
+	}
+
+	rel := layoutFieldRgx.ReplaceAllStringFunc(job.layout, func(f string) string {
+		return layoutValue(f[1:len(f)-1], fields[f[1:len(f)-1]])
+	})
+
+	dest := filepath.Join(job.mainFolder, filepath.FromSlash(rel))
+	if r, err := filepath.Rel(job.mainFolder, dest); err != nil || r == "." || r == ".." ||
+		strings.HasPrefix(r, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("[OUTSIDE DESTINATION] [%s]", dest)
+	}
+
+	return dest, nil
+}
+
+// layoutValue field value which is safe inside file path, tags and slug are set by blog author,
+// so value starting with dot (. or .. segment, hidden file) get its dots replaced
+func layoutValue(field, v string) string {
+	v = strings.TrimSpace(unsafeNameRgx.ReplaceAllString(v, "-"))
+	if field != "ext" && strings.HasPrefix(v, ".") {
+		v = "-" + strings.TrimLeft(v, ".")
+	}
+
+	return v
+}
+
+// location timezone of blog, unknown timezone is UTC
+func (job *tumblrJob) location(tz string) *time.Location {
+	if loc, ok := job.zones[tz]; ok {
+		return loc
+	}
+
+	loc, err := time.LoadLocation(tz)
+	if tz == "" || err != nil {
+		loc = time.UTC
+	}
+	job.zones[tz] = loc
+
+	return loc
+}
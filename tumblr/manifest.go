@@ -0,0 +1,333 @@
+// Copyright (c) 2016 - Sarjono Mukti Aji <me@simukti.net>
+// Unless otherwise noted, this source code license is MIT-License
+
+package tumblr
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// MANIFESTFILE per blog manifest file name, stored inside blog folder
+	MANIFESTFILE = ".tmd-manifest.jsonl"
+
+	// STATUSDONE file is completely downloaded
+	STATUSDONE = "done"
+
+	// STATUSFAILED file download failed, it will be retried on next run
+	STATUSFAILED = "failed"
+
+	// STATUSPARTIAL file download started, its .part file is resumed if run is killed before it ends
+	STATUSPARTIAL = "partial"
+)
+
+// ManifestEntry single media file record
+type ManifestEntry struct {
+	PostID string `json:"post_id"`
+	Media  string `json:"media"`
+	URL    string `json:"url"`
+	Dest   string `json:"dest"` // relative to main destination folder
+	Size   int64  `json:"size"`
+	Hash   string `json:"sha256,omitempty"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+	// ETag or Last-Modified of partial or failed download, its .part file is resumed on next run
+	Validator string    `json:"validator,omitempty"`
+	Started   time.Time `json:"started"`
+	Finished  time.Time `json:"finished"`
+}
+
+// Path full path of recorded file inside main destination folder dest
+func (e ManifestEntry) Path(dest string) string {
+	if filepath.IsAbs(e.Dest) {
+		return e.Dest
+	}
+
+	return filepath.Join(dest, e.Dest)
+}
+
+// intact check if file is completely downloaded and still on disk with its recorded size,
+// deleted or truncated file is downloaded again
+func (e ManifestEntry) intact(dest string) bool {
+	if e.Status != STATUSDONE {
+		return false
+	}
+	s, err := os.Stat(e.Path(dest))
+
+	return err == nil && s.Size() == e.Size
+}
+
+// PageProgress last completed page of single media job
+type PageProgress struct {
+	Media    string    `json:"media"`
+	PerPage  int       `json:"per_page"`
+	Page     int       `json:"page"`
+	Total    int       `json:"total"`
+	Complete bool      `json:"complete"`
+	Updated  time.Time `json:"updated"`
+}
+
+// SyncMark newest post seen on last finished run of single media job
+type SyncMark struct {
+	Media     string    `json:"media"`
+	PostID    string    `json:"post_id"`
+	Timestamp int       `json:"timestamp"`
+	Updated   time.Time `json:"updated"`
+}
+
+// manifestRecord single line of manifest file, only one field is set
+type manifestRecord struct {
+	File *ManifestEntry `json:"file,omitempty"`
+	Page *PageProgress  `json:"page,omitempty"`
+	Sync *SyncMark      `json:"sync,omitempty"`
+}
+
+// Manifest append only log of downloaded files and page progress of single blog,
+// later record of the same url or media replace the previous one.
+type Manifest struct {
+	mu    sync.Mutex
+	path  string
+	fresh bool // manifest file did not exists before
+	w     *os.File
+	files map[string]*ManifestEntry
+	pages map[string]*PageProgress
+	syncs map[string]*SyncMark
+}
+
+func newManifest(path string) *Manifest {
+	return &Manifest{
+		path:  path,
+		files: map[string]*ManifestEntry{},
+		pages: map[string]*PageProgress{},
+		syncs: map[string]*SyncMark{},
+	}
+}
+
+// OpenManifest load manifest file, it is created if not exists
+func OpenManifest(path string) (*Manifest, error) {
+	m := newManifest(path)
+
+	r, err := os.Open(path)
+	switch {
+	case os.IsNotExist(err):
+		m.fresh = true
+	case err != nil:
+		return nil, err
+	default:
+		defer r.Close()
+		if err := m.load(r); err != nil {
+			return nil, err
+		}
+	}
+
+	w, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	m.w = w
+
+	return m, nil
+}
+
+// ReadManifest load existing manifest file as read only, nothing can be recorded into it
+func ReadManifest(path string) (*Manifest, error) {
+	r, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	m := newManifest(path)
+	if err := m.load(r); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+func (m *Manifest) load(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		rec := manifestRecord{}
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			// interrupted write on last line, ignore it
+			continue
+		}
+		if rec.File != nil {
+			m.files[rec.File.URL] = rec.File
+		}
+		if rec.Page != nil {
+			m.pages[rec.Page.Media] = rec.Page
+		}
+		if rec.Sync != nil {
+			m.syncs[rec.Sync.Media] = rec.Sync
+		}
+	}
+
+	return scanner.Err()
+}
+
+// Lookup return record of given media url
+func (m *Manifest) Lookup(fileURL string) (ManifestEntry, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, ok := m.files[fileURL]
+	if !ok {
+		return ManifestEntry{}, false
+	}
+
+	return *e, true
+}
+
+// Record append file record
+func (m *Manifest) Record(e ManifestEntry) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.files[e.URL] = &e
+
+	return m.write(manifestRecord{File: &e})
+}
+
+// Progress return last page progress of given media
+func (m *Manifest) Progress(media string) (PageProgress, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	p, ok := m.pages[media]
+	if !ok {
+		return PageProgress{}, false
+	}
+
+	return *p, true
+}
+
+// SetProgress append page progress record
+func (m *Manifest) SetProgress(p PageProgress) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	p.Updated = time.Now()
+	m.pages[p.Media] = &p
+
+	return m.write(manifestRecord{Page: &p})
+}
+
+// LastSync return newest post seen on last finished run of given media
+func (m *Manifest) LastSync(media string) (SyncMark, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.syncs[media]
+	if !ok {
+		return SyncMark{}, false
+	}
+
+	return *s, true
+}
+
+// SetLastSync append sync mark record
+func (m *Manifest) SetLastSync(s SyncMark) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s.Updated = time.Now()
+	m.syncs[s.Media] = &s
+
+	return m.write(manifestRecord{Sync: &s})
+}
+
+// Entries all file records sorted by destination
+func (m *Manifest) Entries() []ManifestEntry {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entries := make([]ManifestEntry, 0, len(m.files))
+	for _, e := range m.files {
+		entries = append(entries, *e)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Dest < entries[j].Dest
+	})
+
+	return entries
+}
+
+// Media every media which has file, page progress or sync record, sorted by name
+func (m *Manifest) Media() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	seen := map[string]bool{}
+	for _, e := range m.files {
+		seen[e.Media] = true
+	}
+	for media := range m.pages {
+		seen[media] = true
+	}
+	for media := range m.syncs {
+		seen[media] = true
+	}
+
+	media := make([]string, 0, len(seen))
+	for k := range seen {
+		media = append(media, k)
+	}
+	sort.Strings(media)
+
+	return media
+}
+
+// Close close manifest file
+func (m *Manifest) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.w == nil {
+		return nil
+	}
+
+	return m.w.Close()
+}
+
+func (m *Manifest) write(rec manifestRecord) error {
+	if m.w == nil {
+		return fmt.Errorf("Manifest %s is read only", m.path)
+	}
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	_, err = m.w.Write(append(b, '\n'))
+
+	return err
+}
+
+// fileHash sha256 hex digest of given file
+func fileHash(path string) (string, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return "", 0, err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), n, nil
+}
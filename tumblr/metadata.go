@@ -0,0 +1,198 @@
+// Copyright (c) 2016 - Sarjono Mukti Aji <me@simukti.net>
+// Unless otherwise noted, this source code license is MIT-License
+
+package tumblr
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/fatih/color"
+)
+
+const (
+	// METANONE no post metadata is written
+	METANONE = "none"
+
+	// METAPOST one json sidecar file per post, next to its media
+	METAPOST = "post"
+
+	// METAJSONL one json lines file per blog
+	METAJSONL = "jsonl"
+
+	// METAFILE json lines metadata file name, stored inside blog folder
+	METAFILE = "posts.jsonl"
+)
+
+var allowedMeta = map[string]bool{METANONE: true, METAPOST: true, METAJSONL: true}
+
+// PostMetadata full parsed post and its local files
+type PostMetadata struct {
+	Blog  string   `json:"blog"`
+	Media string   `json:"media"`
+	Post  Post     `json:"post"`
+	Files []string `json:"files"` // relative to main destination folder, in post order
+}
+
+// metaWriter write PostMetadata of single blog job
+type metaWriter struct {
+	mu    sync.Mutex
+	mode  string
+	jsonl *os.File
+	seen  map[string]bool // post id already in json lines file
+}
+
+// newMetaWriter open metadata writer, json lines file is created inside blog folder
+func newMetaWriter(mode, userDir string) (*metaWriter, error) {
+	mw := &metaWriter{mode: mode, seen: map[string]bool{}}
+	if mode != METAJSONL {
+		return mw, nil
+	}
+
+	path := filepath.Join(userDir, METAFILE)
+	if r, err := os.Open(path); err == nil {
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+		for scanner.Scan() {
+			pm := PostMetadata{}
+			if json.Unmarshal(scanner.Bytes(), &pm) == nil {
+				mw.seen[pm.Post.ID] = true
+			}
+		}
+		r.Close()
+	}
+
+	w, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	mw.jsonl = w
+
+	return mw, nil
+}
+
+// write post metadata, it is skipped if already written and no new file is downloaded
+func (mw *metaWriter) write(pm *PostMetadata, sidecar string, fresh bool) error {
+	mw.mu.Lock()
+	defer mw.mu.Unlock()
+
+	switch mw.mode {
+	case METAJSONL:
+		if mw.seen[pm.Post.ID] && !fresh {
+			return nil
+		}
+		b, err := json.Marshal(pm)
+		if err != nil {
+			return err
+		}
+		mw.seen[pm.Post.ID] = true
+		_, err = mw.jsonl.Write(append(b, '\n'))
+
+		return err
+	case METAPOST:
+		return mw.writeSidecar(pm, sidecar, fresh)
+	}
+
+	return nil
+}
+
+// writeSidecar write post metadata as single json file, it is replaced atomically
+func (mw *metaWriter) writeSidecar(pm *PostMetadata, sidecar string, fresh bool) error {
+	if _, err := os.Stat(sidecar); err == nil && !fresh {
+		return nil
+	}
+	b, err := json.MarshalIndent(pm, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(sidecar), 0700); err != nil {
+		return err
+	}
+	tmp := sidecar + PARTSUFFIX
+	if err := ioutil.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, sidecar)
+}
+
+func (mw *metaWriter) close() error {
+	if mw.jsonl == nil {
+		return nil
+	}
+
+	return mw.jsonl.Close()
+}
+
+// postRecord collect local files of single post until all of them are finished
+type postRecord struct {
+	mu      sync.Mutex
+	job     *tumblrJob
+	meta    PostMetadata
+	sidecar string
+	pending int
+	fresh   bool // at least one file is downloaded on this run
+}
+
+func newPostRecord(job *tumblrJob, t *Tumblr, p *Post, files int) (*postRecord, error) {
+	sidecar, err := t.postFile(job, p, ".json")
+	if err != nil {
+		return nil, err
+	}
+
+	return &postRecord{
+		job: job,
+		meta: PostMetadata{
+			Blog:  t.TumbleBlog.Name,
+			Media: job.media,
+			Post:  *p,
+			Files: make([]string, files),
+		},
+		sidecar: sidecar,
+		pending: 1, // released by processPage once every file is queued
+	}, nil
+}
+
+// queue register single file which is still downloading
+func (pr *postRecord) queue() {
+	pr.mu.Lock()
+	pr.pending++
+	pr.mu.Unlock()
+}
+
+// finish mark file at given post index as finished, failed file has empty dest
+func (pr *postRecord) finish(index int, dest string, downloaded bool) {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+
+	if index >= 0 && dest != "" {
+		if rel, err := filepath.Rel(pr.job.mainFolder, dest); err == nil {
+			dest = rel
+		}
+		pr.meta.Files[index] = dest
+	}
+	pr.fresh = pr.fresh || downloaded
+	pr.pending--
+	if pr.pending > 0 {
+		return
+	}
+
+	files := []string{}
+	for _, f := range pr.meta.Files {
+		if f != "" {
+			files = append(files, f)
+		}
+	}
+	pr.meta.Files = files
+
+	if err := pr.job.meta.write(&pr.meta, pr.sidecar, pr.fresh); err != nil {
+		msg := color.New(color.FgHiRed, color.Bold).
+			SprintfFunc()("\t[ERROR METADATA] [%s] %s", pr.meta.Post.ID, err.Error())
+		fmt.Fprintln(pr.job.out, msg)
+	}
+}
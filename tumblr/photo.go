@@ -0,0 +1,114 @@
+// Copyright (c) 2016 - Sarjono Mukti Aji <me@simukti.net>
+// Unless otherwise noted, this source code license is MIT-License
+
+package tumblr
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	// PHOTOLARGEST download largest available photo variant
+	PHOTOLARGEST = "largest"
+
+	// PHOTOORIGINALHOST host of tumblr original (raw) photo
+	PHOTOORIGINALHOST = "data.tumblr.com"
+)
+
+// tumblr_<name>_<width>.<ext>, name may contain _r1 revision
+var photoSizeRgx = regexp.MustCompile(`^(.*/tumblr_\w+?)_(\d+)(\.\w+)$`)
+
+// parsePhotoSize parse photo size policy, PHOTOLARGEST is empty list,
+// single width is max width and several widths is fallback chain
+func parsePhotoSize(s string) ([]int, error) {
+	s = strings.TrimSpace(s)
+	if s == "" || s == PHOTOLARGEST {
+		return []int{}, nil
+	}
+
+	widths := []int{}
+	for _, w := range strings.Split(s, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(w))
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("Photo size must be %s, max width or comma separated widths, got %s", PHOTOLARGEST, s)
+		}
+		widths = append(widths, n)
+	}
+
+	return widths, nil
+}
+
+// pickPhotoURL choose single variant of photo,
+// no width is largest one, single width is largest one not wider than it (or smallest one),
+// several widths is first listed width available (or largest one)
+func pickPhotoURL(pus []PhotoURL, widths []int) (PhotoURL, bool) {
+	if len(pus) == 0 {
+		return PhotoURL{}, false
+	}
+
+	largest, smallest := pus[0], pus[0]
+	for _, pu := range pus {
+		if pu.MaxWidth > largest.MaxWidth {
+			largest = pu
+		}
+		if pu.MaxWidth < smallest.MaxWidth {
+			smallest = pu
+		}
+	}
+
+	switch len(widths) {
+	case 0:
+		return largest, true
+	case 1:
+		best, found := smallest, false
+		for _, pu := range pus {
+			if pu.MaxWidth <= widths[0] && (!found || pu.MaxWidth > best.MaxWidth) {
+				best, found = pu, true
+			}
+		}
+		return best, true
+	}
+
+	for _, w := range widths {
+		for _, pu := range pus {
+			if pu.MaxWidth == w {
+				return pu, true
+			}
+		}
+	}
+
+	return largest, true
+}
+
+// photoCandidates higher resolution url of tumblr hosted photo which are tried before given url,
+// original (raw) photo first then 1280 variant of smaller photo
+func photoCandidates(photoURL string) []string {
+	candidates := []string{}
+	pu, err := url.Parse(photoURL)
+	if err != nil || !strings.HasSuffix(pu.Host, "media.tumblr.com") {
+		return candidates
+	}
+
+	match := photoSizeRgx.FindStringSubmatch(pu.Path)
+	if len(match) != 4 {
+		return candidates
+	}
+
+	raw := *pu
+	raw.Scheme = "https"
+	raw.Host = PHOTOORIGINALHOST
+	raw.Path = match[1] + "_raw" + match[3]
+	candidates = append(candidates, raw.String())
+
+	if w, _ := strconv.Atoi(match[2]); w < 1280 {
+		hd := *pu
+		hd.Path = match[1] + "_1280" + match[3]
+		candidates = append(candidates, hd.String())
+	}
+
+	return candidates
+}
@@ -0,0 +1,110 @@
+// Copyright (c) 2016 - Sarjono Mukti Aji <me@simukti.net>
+// Unless otherwise noted, this source code license is MIT-License
+
+package tumblr
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/fatih/color"
+)
+
+// PlannedFile single file listed on dry run, written as json line into PlanOutput
+type PlannedFile struct {
+	Blog   string `json:"blog"`
+	Media  string `json:"media"`
+	PostID string `json:"post_id"`
+	URL    string `json:"url"`
+	Dest   string `json:"dest"`
+	Exists bool   `json:"exists"` // file is already in destination folder
+}
+
+type planCount struct {
+	files  int
+	exists int
+}
+
+// dryRunManifest load manifest without opening it for writing, missing manifest is fresh one
+func dryRunManifest(path string) (*Manifest, error) {
+	m, err := ReadManifest(path)
+	if os.IsNotExist(err) {
+		m, err = newManifest(path), nil
+		m.fresh = true
+	}
+
+	return m, err
+}
+
+// plan list single file of dry run instead of downloading it
+func (job *tumblrJob) plan(postID, fileURL, dest string) {
+	exists, size := false, int64(0)
+	if e, ok := job.manifest.Lookup(fileURL); ok && e.Status == STATUSDONE {
+		// recorded file is listed on its recorded destination, it may differ from current layout
+		dest = e.Path(job.mainFolder)
+		exists, size = e.intact(job.mainFolder), e.Size
+	} else if s, err := os.Stat(dest); err == nil {
+		exists, size = true, s.Size()
+	}
+
+	if exists {
+		job.downloader.addFile(size, 0)
+	} else {
+		job.downloader.addPlanned()
+	}
+
+	c, ok := job.planned[job.media]
+	if !ok {
+		c = &planCount{}
+		job.planned[job.media] = c
+	}
+	c.files++
+
+	if exists {
+		c.exists++
+		fmt.Fprintln(job.out, color.WhiteString("\t[PLAN] [EXISTS] [%s] -> [%s]", fileURL, dest))
+	} else {
+		fmt.Fprintln(job.out, color.GreenString("\t[PLAN] [NEW] [%s] -> [%s]", fileURL, dest))
+	}
+
+	if job.planOut != nil {
+		pf := PlannedFile{
+			Blog:   job.username,
+			Media:  job.media,
+			PostID: postID,
+			URL:    fileURL,
+			Dest:   dest,
+			Exists: exists,
+		}
+		if err := job.downloader.writePlan(job.planOut, pf); err != nil {
+			msg := color.New(color.FgHiRed, color.Bold).SprintfFunc()("\t[ERROR PLAN] %s", err.Error())
+			fmt.Fprintln(job.out, msg)
+		}
+	}
+}
+
+// planSummary print listed files per media of dry run
+func (job *tumblrJob) planSummary() {
+	for _, m := range allMedia {
+		if c, ok := job.planned[m]; ok {
+			fmt.Fprintln(job.out, color.GreenString("[PLAN] [%s] [%s] %d files, %d new, %d exist",
+				job.username, m, c.files, c.files-c.exists, c.exists))
+		}
+	}
+}
+
+// writePlan write json line of planned file, output is shared by every job of Downloader
+func (d *Downloader) writePlan(w io.Writer, pf PlannedFile) error {
+	b, err := json.Marshal(pf)
+	if err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	_, err = w.Write(append(b, '\n'))
+
+	return err
+}
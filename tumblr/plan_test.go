@@ -0,0 +1,137 @@
+// Copyright (c) 2016 - Sarjono Mukti Aji <me@simukti.net>
+// Unless otherwise noted, this source code license is MIT-License
+
+package tumblr
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// readPlan planned files of PlanOutput per url
+func readPlan(t *testing.T, out string) map[string]PlannedFile {
+	t.Helper()
+
+	plan := map[string]PlannedFile{}
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		pf := PlannedFile{}
+		if err := json.Unmarshal([]byte(line), &pf); err != nil {
+			t.Fatalf("plan line %s: %v", line, err)
+		}
+		plan[pf.URL] = pf
+	}
+
+	return plan
+}
+
+// tree every file inside dir, relative to it
+func tree(t *testing.T, dir string) []string {
+	t.Helper()
+
+	files := []string{}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			rel, _ := filepath.Rel(dir, path)
+			files = append(files, rel)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(files)
+
+	return files
+}
+
+func TestDryRun(t *testing.T) {
+	f := newFakeTumblr()
+	defer f.Close()
+	f.addPosts(PHOTO, photoPosts(1000, 1500000000, 2)...)
+	f.addPosts(VIDEO,
+		videoPost("20", 1500000000, "tumblr_v"),
+		embedPost("21", 1499990000, "https://www.youtube.com/embed/dQw4w9WgXcQ"),
+	)
+	f.addPosts(TEXT, `<post id="40" unix-timestamp="1500000000" type="regular"><regular-title>t</regular-title></post>`)
+	dest, clean := tempDest(t)
+	defer clean()
+
+	photo1, photo2 := photoFile(dest, 1000, 1500000000), photoFile(dest, 999, 1500000000-86400)
+	video := filepath.Join(dest, FAKEBLOG, VIDEO, "20_1500000000_tumblr_v.mp4")
+	doc := filepath.Join(dest, FAKEBLOG, TEXT, "40_1500000000.html")
+	docURL := fmt.Sprintf(BASEURL, FAKEBLOG) + "/post/40"
+	urls := map[string]string{
+		FAKEMEDIAHOST + "/p/tumblr_1000_1280.jpg": photo1,
+		FAKEMEDIAHOST + "/p/tumblr_999_1280.jpg":  photo2,
+		FAKEMEDIAHOST + "/video_file/tumblr_v":    video,
+		docURL:                                    doc,
+	}
+	dryRun := func() (Stats, string, map[string]PlannedFile) {
+		plan := &lockedBuffer{}
+		stats, out := f.download(t, dest, Media("photo,video,text"), DryRun(true), PlanOutput(plan),
+			Metadata(METAPOST), EmbedCommand("touch embedded"))
+		return stats, out, readPlan(t, plan.String())
+	}
+
+	// nothing is written before first download, not even blog folder
+	stats, out, plan := dryRun()
+	if files := tree(t, dest); len(files) != 0 {
+		t.Errorf("dry run wrote %v", files)
+	}
+	mustNotExist(t, filepath.Join(dest, FAKEBLOG))
+	if stats.Planned != 4 || stats.Files != 0 || stats.Downloaded != 0 || !strings.Contains(out, "[EMBEDDED] [youtube]") {
+		t.Errorf("stats = %+v\n%s", stats, out)
+	}
+	if len(plan) != len(urls) {
+		t.Errorf("plan = %+v", plan)
+	}
+	for u, want := range urls {
+		if pf := plan[u]; pf.Dest != want || pf.Exists || pf.Blog != FAKEBLOG || pf.PostID == "" {
+			t.Errorf("planned %s = %+v, want new file %s", u, pf, want)
+		}
+	}
+
+	// recorded intact file exists, recorded missing one and unrecorded one are new, unrecorded file on disk exists
+	f.download(t, dest, Media(PHOTO))
+	if err := os.Remove(photo2); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Dir(video), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(video, []byte("video"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	manifest, err := ioutil.ReadFile(filepath.Join(dest, FAKEBLOG, MANIFESTFILE))
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := tree(t, dest)
+
+	stats, out, plan = dryRun()
+	exists := map[string]bool{photo1: true, photo2: false, video: true, doc: false}
+	for u, want := range urls {
+		if pf := plan[u]; pf.Dest != want || pf.Exists != exists[want] {
+			t.Errorf("planned %s = %+v, want %s exists %v", u, pf, want, exists[want])
+		}
+	}
+	if stats.Planned != 2 || stats.Files != 2 || !strings.Contains(out, "[PLAN] [fake] [photo] 2 files, 1 new, 1 exist") {
+		t.Errorf("stats = %+v\n%s", stats, out)
+	}
+	if after := tree(t, dest); strings.Join(after, ",") != strings.Join(before, ",") {
+		t.Errorf("dry run changed files %v, want %v", after, before)
+	}
+	if after, _ := ioutil.ReadFile(filepath.Join(dest, FAKEBLOG, MANIFESTFILE)); !bytes.Equal(after, manifest) {
+		t.Errorf("dry run changed manifest\n%s\nwant\n%s", after, manifest)
+	}
+}
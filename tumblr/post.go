@@ -0,0 +1,129 @@
+// Copyright (c) 2016 - Sarjono Mukti Aji <me@simukti.net>
+// Unless otherwise noted, this source code license is MIT-License
+
+package tumblr
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/fatih/color"
+)
+
+var (
+	postIDRgx   = regexp.MustCompile(`^\d+$`)
+	postPathRgx = regexp.MustCompile(`^/post/(\d+)(?:/|$)`)
+	dashPathRgx = regexp.MustCompile(`^/(?:blog/view/)?([\w-]+)/(\d+)(?:/|$)`)
+)
+
+// ParsePost extract blog and post id from post url, such as https://name.tumblr.com/post/123/slug,
+// https://www.tumblr.com/name/123 or custom domain https://example.com/post/123, see ParseBlog.
+// Bare post id is accepted with empty blog name.
+func ParsePost(s string) (string, string, error) {
+	s = strings.TrimSpace(s)
+	if postIDRgx.MatchString(s) {
+		return "", s, nil
+	}
+
+	raw := s
+	if !strings.Contains(raw, "://") {
+		raw = "https://" + raw
+	}
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return "", "", fmt.Errorf("Invalid post url or id %s", s)
+	}
+
+	host := strings.ToLower(u.Hostname())
+	switch {
+	case host == "tumblr.com" || host == "www.tumblr.com":
+		if m := dashPathRgx.FindStringSubmatch(u.Path); len(m) == 3 {
+			return strings.ToLower(m[1]), m[2], nil
+		}
+	default:
+		m := postPathRgx.FindStringSubmatch(u.Path)
+		if blog, err := ParseBlog(host); err == nil && len(m) == 2 {
+			return blog, m[1], nil
+		}
+	}
+
+	return "", "", fmt.Errorf("Invalid post url or id %s", s)
+}
+
+// mediaOf media name of legacy post type, empty if post type is unknown
+func mediaOf(postType string) string {
+	for m, pt := range postTypes {
+		if pt == postType {
+			return m
+		}
+	}
+
+	return ""
+}
+
+// processPosts fetch and queue only given posts, each post goes into folder of its own media.
+// Page progress and sync mark are untouched since no page is crawled.
+func (job *tumblrJob) processPosts(userURL string) error {
+	out := job.out
+	wanted := map[string]bool{}
+	for _, m := range mediaTypes(job.media) {
+		wanted[m] = true
+	}
+	client := job.httpClient(job.connectTimeout)
+
+	for _, id := range job.postIDs {
+		query := Query{
+			Blog:    job.username,
+			BlogURL: userURL,
+			Num:     1,
+			ID:      id,
+		}
+		var blog *Tumblr
+		err := job.pageRetry.do(out, "POST "+id, func() (err error) {
+			blog, err = job.backend.Posts(client, query)
+			return err
+		})
+		if err != nil {
+			job.downloader.addFailedPage()
+			msg := color.New(color.FgHiRed, color.Bold).SprintfFunc()("[ERROR POST %s] [%s]", id, err.Error())
+			fmt.Fprintln(out, msg)
+			continue
+		}
+
+		fmt.Fprintln(out,
+			color.CyanString(
+				"\n====================================[%s] [POST %s]====================================",
+				strings.ToUpper(userURL),
+				id,
+			))
+
+		if len(blog.Posts.Posts) == 0 {
+			fmt.Fprintln(out, color.YellowString("\t[SKIPPED] [%s] [POST NOT FOUND]", id))
+			continue
+		}
+
+		// -after and -before apply to given posts too
+		if blog.dropOutside(job.after, job.before); len(blog.Posts.Posts) == 0 {
+			fmt.Fprintln(out, color.YellowString("\t[SKIPPED] [%s] [OUTSIDE DATE RANGE]", id))
+			continue
+		}
+
+		posts := blog.Posts.Posts
+		for _, p := range posts {
+			media := mediaOf(p.Type)
+			if !wanted[media] {
+				fmt.Fprintln(out, color.YellowString("\t[SKIPPED] [%s] [%s POST]", p.ID, strings.ToUpper(p.Type)))
+				continue
+			}
+
+			job.media = media
+
+			blog.Posts.Posts = []Post{p}
+			blog.processPage(job, nil, 0)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,120 @@
+// Copyright (c) 2016 - Sarjono Mukti Aji <me@simukti.net>
+// Unless otherwise noted, this source code license is MIT-License
+
+package tumblr
+
+import "sync"
+
+// pageTracker record page progress into manifest once every file of a page
+// and of all pages before it are finished, since downloads run behind page fetching.
+// Nothing is recorded if manifest is nil.
+type pageTracker struct {
+	mu       sync.Mutex
+	manifest *Manifest
+	media    string
+	perPage  int
+	total    int
+	done     int          // every page up to this one is finished
+	pending  map[int]int  // unfinished files per fetched page, failed page is never listed
+	failed   map[int]bool // fetched page which has failed file, like failed page it is never done
+	stopped  bool
+	last     int       // last fetched page, only valid once stopped
+	mark     *SyncMark // written once last page is finished
+}
+
+func newPageTracker(manifest *Manifest, media string, perPage, total, done int) *pageTracker {
+	return &pageTracker{
+		manifest: manifest,
+		media:    media,
+		perPage:  perPage,
+		total:    total,
+		done:     done,
+		pending:  map[int]int{},
+		failed:   map[int]bool{},
+	}
+}
+
+// open register fetched page, it stay unfinished until finish is called once more than add
+func (pt *pageTracker) open(page int) {
+	pt.add(page)
+}
+
+// add register single queued file of given page
+func (pt *pageTracker) add(page int) {
+	pt.mu.Lock()
+	pt.pending[page]++
+	pt.mu.Unlock()
+}
+
+// finish mark single file (or the page itself) as finished
+func (pt *pageTracker) finish(page int) {
+	pt.mu.Lock()
+	defer pt.mu.Unlock()
+
+	pt.pending[page]--
+	pt.advance()
+}
+
+// fail mark page as having failed file, so neither page progress nor sync mark move past it
+// and the file is retried by next run
+func (pt *pageTracker) fail(page int) {
+	pt.mu.Lock()
+	pt.failed[page] = true
+	pt.mu.Unlock()
+}
+
+// stop tell that paging is done at given page, sync mark is written once it is finished
+func (pt *pageTracker) stop(last int, mark *SyncMark) {
+	pt.mu.Lock()
+	defer pt.mu.Unlock()
+
+	pt.stopped = true
+	pt.last = last
+	pt.mark = mark
+	if pt.done >= pt.last {
+		pt.complete()
+		return
+	}
+	pt.advance()
+}
+
+func (pt *pageTracker) advance() {
+	for {
+		next := pt.done + 1
+		if n, ok := pt.pending[next]; !ok || n > 0 || pt.failed[next] {
+			return
+		}
+		delete(pt.pending, next)
+		pt.done = next
+
+		if pt.stopped && pt.done == pt.last {
+			pt.complete()
+			return
+		}
+		if pt.manifest == nil {
+			continue
+		}
+		pt.manifest.SetProgress(PageProgress{
+			Media:   pt.media,
+			PerPage: pt.perPage,
+			Page:    pt.done,
+			Total:   pt.total,
+		})
+	}
+}
+
+func (pt *pageTracker) complete() {
+	if pt.manifest == nil {
+		return
+	}
+	pt.manifest.SetProgress(PageProgress{
+		Media:    pt.media,
+		PerPage:  pt.perPage,
+		Page:     pt.done,
+		Total:    pt.total,
+		Complete: true,
+	})
+	if pt.mark != nil {
+		pt.manifest.SetLastSync(*pt.mark)
+	}
+}
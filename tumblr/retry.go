@@ -0,0 +1,117 @@
+// Copyright (c) 2016 - Sarjono Mukti Aji <me@simukti.net>
+// Unless otherwise noted, this source code license is MIT-License
+
+package tumblr
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+// RetryPolicy how failed api page or media request is retried
+type RetryPolicy struct {
+	Attempts   int           // total attempts, 1 means no retry
+	Backoff    time.Duration // delay before second attempt, doubled on every next attempt
+	MaxBackoff time.Duration // delay upper limit, Retry-After header may exceed it
+	Jitter     float64       // random fraction of delay added or removed, 0.0 - 1.0
+}
+
+var (
+	// DefaultPageRetry default retry policy of api page request
+	DefaultPageRetry = RetryPolicy{Attempts: 4, Backoff: 2 * time.Second, MaxBackoff: time.Minute, Jitter: 0.2}
+
+	// DefaultMediaRetry default retry policy of media file request
+	DefaultMediaRetry = RetryPolicy{Attempts: 3, Backoff: 5 * time.Second, MaxBackoff: 2 * time.Minute, Jitter: 0.2}
+)
+
+// StatusError non successful http response
+type StatusError struct {
+	StatusCode int
+	URL        string
+	RetryAfter time.Duration // from Retry-After header, 0 if not given
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("[%d] [%s]", e.StatusCode, e.URL)
+}
+
+// Temporary check if request may succeed later, 404 or 403 will never do
+func (e *StatusError) Temporary() bool {
+	switch e.StatusCode {
+	case http.StatusRequestTimeout, http.StatusTooManyRequests,
+		http.StatusInternalServerError, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+
+	return false
+}
+
+func newStatusError(resp *http.Response, fileURL string) *StatusError {
+	e := &StatusError{StatusCode: resp.StatusCode, URL: fileURL}
+
+	// Retry-After is either seconds or http date
+	if ra := resp.Header.Get("Retry-After"); ra != "" {
+		if sec, err := strconv.Atoi(ra); err == nil && sec > 0 {
+			e.RetryAfter = time.Duration(sec) * time.Second
+		} else if t, err := http.ParseTime(ra); err == nil {
+			e.RetryAfter = time.Until(t)
+		}
+	}
+
+	return e
+}
+
+// retryable classify error, network error and temporary http status are retried
+func retryable(err error) bool {
+	switch e := err.(type) {
+	case *StatusError:
+		return e.Temporary()
+	case net.Error:
+		return true
+	}
+
+	return err == io.ErrUnexpectedEOF
+}
+
+// do call fn until it succeed, return permanent error or attempts are exhausted
+func (p RetryPolicy) do(out io.Writer, label string, fn func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= p.Attempts || !retryable(err) {
+			return err
+		}
+
+		delay := p.delay(attempt, err)
+		msg := color.New(color.FgYellow).
+			SprintfFunc()("\t[RETRY %d/%d] [%s] [%s] IN %s", attempt, p.Attempts-1, label, err.Error(), delay)
+		fmt.Fprintln(out, msg)
+		time.Sleep(delay)
+	}
+}
+
+// delay exponential backoff with jitter, Retry-After header win if it is longer
+func (p RetryPolicy) delay(attempt int, err error) time.Duration {
+	d := p.Backoff << uint(attempt-1)
+	// zero backoff never wait, shifted positive backoff only get <= 0 on overflow
+	if p.MaxBackoff > 0 && (d > p.MaxBackoff || (p.Backoff > 0 && d <= 0)) {
+		d = p.MaxBackoff
+	}
+
+	if p.Jitter > 0 {
+		d += time.Duration((rand.Float64()*2 - 1) * p.Jitter * float64(d))
+	}
+
+	if se, ok := err.(*StatusError); ok && se.RetryAfter > d {
+		d = se.RetryAfter
+	}
+
+	return d
+}
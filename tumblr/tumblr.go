@@ -0,0 +1,273 @@
+// Copyright (c) 2016 - Sarjono Mukti Aji <me@simukti.net>
+// Unless otherwise noted, this source code license is MIT-License
+
+// Package tumblr download photos and videos from any tumblr blog,
+// text, quote, link, chat, answer and audio posts are archived as html documents
+// and tumblr hosted audio files are downloaded too.
+//
+// All settings are given as Option to New, and every blog download can
+// override them per call, so several archive jobs can share one process:
+//
+//	d, err := tumblr.New(tumblr.Destination("/tmp"), tumblr.Media(tumblr.PHOTO))
+//	if err != nil {
+//		// handle error
+//	}
+//	err = d.Download("yahoo")
+//	fmt.Println(d.Stats().Files)
+package tumblr
+
+import (
+	"strconv"
+	"strings"
+)
+
+const (
+	// BASEURL main tumblr user api base domain
+	BASEURL = "http://%s.tumblr.com"
+
+	// DOMAINURL custom domain blog base url, used instead of default BASEURL
+	DOMAINURL = "http://%s"
+
+	// APIURL main tumblr user api full path
+	APIURL = "%s/api/read?type=%s&num=%d&start=%d"
+
+	// APIPOSTURL single post api full path
+	APIPOSTURL = "%s/api/read?id=%s"
+
+	// DEFAULTSTART default start post number
+	DEFAULTSTART = 0
+
+	// DEFAULTDTO default download timeout
+	DEFAULTDTO = 3600
+
+	// DEFAULTCTO default connect timeout, this is used on first request to get total posts
+	DEFAULTCTO = 15
+
+	// DEFAULTBATCH default files download at once
+	DEFAULTBATCH = 2
+
+	// DEFAULTPERPAGE default posts per page request
+	DEFAULTPERPAGE = 20
+
+	// MAXPERBATCH maximum files download at once
+	MAXPERBATCH = 10
+
+	// DEFAULTQUEUE default files waiting for download, page fetching stop running ahead once it is full
+	DEFAULTQUEUE = 50
+
+	// MAXPERPAGE maximum posts perpage request
+	MAXPERPAGE = 40
+
+	// DEFAULTMEDIA default value for cli -m param
+	DEFAULTMEDIA = "all"
+
+	// PHOTO photo post type
+	PHOTO = "photo"
+
+	// VIDEO video post type
+	VIDEO = "video"
+
+	// TEXT text post type, archived as html document
+	TEXT = "text"
+
+	// QUOTE quote post type, archived as html document
+	QUOTE = "quote"
+
+	// LINK link post type, archived as html document
+	LINK = "link"
+
+	// CHAT chat post type, archived as html document
+	CHAT = "chat"
+
+	// ANSWER answer post type, archived as html document
+	ANSWER = "answer"
+
+	// AUDIO audio post type, archived as html document with its hosted audio file
+	AUDIO = "audio"
+
+	// PARTSUFFIX suffix of partially downloaded file
+	PARTSUFFIX = ".part"
+
+	// BYTE byte unit float
+	BYTE = 1.0
+
+	// KiB Kibibyte
+	KiB = 1024 * BYTE
+
+	// MiB Mebibyte
+	MiB = 1024 * KiB
+
+	// GiB Gibibyte
+	GiB = 1024 * MiB
+)
+
+var (
+	allowedMedia = map[string]bool{
+		"all": true, PHOTO: true, VIDEO: true, TEXT: true, QUOTE: true,
+		LINK: true, CHAT: true, ANSWER: true, AUDIO: true,
+	}
+	allMedia = []string{PHOTO, VIDEO, TEXT, QUOTE, LINK, CHAT, ANSWER, AUDIO}
+
+	// post type attribute of each media, legacy api name differ for text and chat
+	postTypes = map[string]string{
+		PHOTO: "photo", VIDEO: "video", TEXT: "regular", QUOTE: "quote",
+		LINK: "link", CHAT: "conversation", ANSWER: "answer", AUDIO: "audio",
+	}
+
+	// downloaded body must have one of these content type prefix
+	allowedContentTypes = []string{"image/", "video/", "audio/"}
+
+	// every http request will randomly pick one user agent from this string list
+	defaultUserAgents = [...]string{
+		"Mozilla/5.0 (Macintosh; Intel Mac OS X 10.11; rv:48.0) Gecko/20100101 Firefox/48.0",
+		"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_11_6) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/40.0.2214.91 Safari/537.36",
+		"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_11_6) AppleWebKit/601.7.7 (KHTML, like Gecko) Version/9.1.2 Safari/601.7.7",
+		"Mozilla/5.0 (Linux; Android 5.0.2; LG-V410/V41020c Build/LRX22G) AppleWebKit/537.36 (KHTML, like Gecko) Version/4.0 Chrome/34.0.1847.118 Safari/537.36",
+		"Mozilla/5.0 (Linux; Android 6.0.1; Nexus 6P Build/MMB29P) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/47.0.2526.83 Mobile Safari/537.36",
+		"Mozilla/5.0 (Linux; Android 5.1.1; SM-G928X Build/LMY47X) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/47.0.2526.83 Mobile Safari/537.36",
+		"Mozilla/5.0 (Linux; Android 5.0.2; SAMSUNG SM-T550 Build/LRX22G) AppleWebKit/537.36 (KHTML, like Gecko) SamsungBrowser/3.3 Chrome/38.0.2125.102 Safari/537.36",
+		"Mozilla/5.0 (Linux; Android 5.0.2; LG-V410/V41020c Build/LRX22G) AppleWebKit/537.36 (KHTML, like Gecko) Version/4.0 Chrome/34.0.1847.118 Safari/537.36",
+		"Mozilla/5.0 (Windows NT 6.1; WOW64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/47.0.2526.111 Safari/537.36",
+		"Mozilla/5.0 (Windows Phone 10.0; Android 4.2.1; Microsoft; Lumia 950) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/46.0.2486.0 Mobile Safari/537.36 Edge/13.10586",
+	}
+)
+
+// Tumblr parent xml result
+type Tumblr struct {
+	TumbleBlog TumbleBlog `xml:"tumblelog" json:"tumblelog"`
+	Posts      Posts      `xml:"posts" json:"posts"`
+}
+
+// mediaTypes split comma separated media, "all" anywhere is every media type
+func mediaTypes(media string) []string {
+	mt := []string{}
+	for _, m := range strings.Split(media, ",") {
+		m = strings.ToLower(strings.TrimSpace(m))
+		if m == "all" {
+			return allMedia
+		}
+		if m != "" {
+			mt = append(mt, m)
+		}
+	}
+
+	return mt
+}
+
+// TumbleBlog detail of current tumblr blog
+type TumbleBlog struct {
+	Name      string `xml:"name,attr" json:"name"`
+	Timezone  string `xml:"timezone,attr" json:"timezone,omitempty"`
+	Canonical string `xml:"cname,attr" json:"cname,omitempty"`
+}
+
+// Posts entities of current page
+type Posts struct {
+	Type  string `xml:"type,attr" json:"type"`
+	Start int    `xml:"start,attr" json:"start"`
+	Total int    `xml:"total,attr" json:"total"`
+	Posts []Post `xml:"post" json:"posts"`
+}
+
+// Post single entity which contain photo/video list
+type Post struct {
+	ID            string        `xml:"id,attr" json:"id"`
+	URL           string        `xml:"url-with-slug,attr" json:"url,omitempty"`
+	Timestamp     int           `xml:"unix-timestamp,attr" json:"timestamp"`
+	Type          string        `xml:"type,attr" json:"type"`
+	Slug          string        `xml:"slug,attr" json:"slug,omitempty"`
+	Tags          []string      `xml:"tag" json:"tags,omitempty"`
+	PhotoCaption  string        `xml:"photo-caption" json:"photo_caption,omitempty"`    // only available in photo media type
+	PhotoWidth    int           `xml:"width,attr" json:"photo_width,omitempty"`         // only available in photo media type
+	PhotoHeight   int           `xml:"height,attr" json:"photo_height,omitempty"`       // only available in photo media type
+	PhotoURLs     []PhotoURL    `xml:"photo-url" json:"photo_urls,omitempty"`           // only available in photo media type
+	PhotoSet      Photoset      `xml:"photoset" json:"photoset"`                        // only available in photo media type (optional)
+	VideoCaption  string        `xml:"video-caption" json:"video_caption,omitempty"`    // only available in video media type
+	IsDirectVideo bool          `xml:"direct-video,attr" json:"direct_video,omitempty"` // only available in video media type
+	VideoPlayer   []VideoPlayer `xml:"video-player" json:"video_player,omitempty"`      // only available in video media type
+	VideoSource   VideoSource   `xml:"video-source" json:"video_source"`                // only available in video media type
+	EmbedURL      string        `xml:"-" json:"embed_url,omitempty"`                    // only available in video media type from api v2
+
+	RegularTitle      string             `xml:"regular-title" json:"regular_title,omitempty"`           // only available in text media type
+	RegularBody       string             `xml:"regular-body" json:"regular_body,omitempty"`             // only available in text media type
+	QuoteText         string             `xml:"quote-text" json:"quote_text,omitempty"`                 // only available in quote media type
+	QuoteSource       string             `xml:"quote-source" json:"quote_source,omitempty"`             // only available in quote media type
+	LinkText          string             `xml:"link-text" json:"link_text,omitempty"`                   // only available in link media type
+	LinkURL           string             `xml:"link-url" json:"link_url,omitempty"`                     // only available in link media type
+	LinkDescription   string             `xml:"link-description" json:"link_description,omitempty"`     // only available in link media type
+	ConversationTitle string             `xml:"conversation-title" json:"conversation_title,omitempty"` // only available in chat media type
+	ConversationText  string             `xml:"conversation-text" json:"conversation_text,omitempty"`   // only available in chat media type
+	Conversation      []ConversationLine `xml:"conversation>line" json:"conversation,omitempty"`        // only available in chat media type
+	Question          string             `xml:"question" json:"question,omitempty"`                     // only available in answer media type
+	Answer            string             `xml:"answer" json:"answer,omitempty"`                         // only available in answer media type
+	AudioCaption      string             `xml:"audio-caption" json:"audio_caption,omitempty"`           // only available in audio media type
+	AudioPlayer       string             `xml:"audio-player" json:"audio_player,omitempty"`             // only available in audio media type
+	ID3Artist         string             `xml:"id3-artist" json:"id3_artist,omitempty"`                 // only available in audio media type
+	ID3Album          string             `xml:"id3-album" json:"id3_album,omitempty"`                   // only available in audio media type
+	ID3Title          string             `xml:"id3-title" json:"id3_title,omitempty"`                   // only available in audio media type
+	AudioURL          string             `xml:"-" json:"audio_url,omitempty"`                           // only available in audio media type from api v2
+
+	RebloggedFromName string `xml:"reblogged-from-name,attr" json:"reblogged_from_name,omitempty"` // empty on original post
+	RebloggedFromURL  string `xml:"reblogged-from-url,attr" json:"reblogged_from_url,omitempty"`   // empty on original post
+	RebloggedRootName string `xml:"reblogged-root-name,attr" json:"reblogged_root_name,omitempty"` // empty on original post
+	RebloggedRootURL  string `xml:"reblogged-root-url,attr" json:"reblogged_root_url,omitempty"`   // empty on original post
+}
+
+// isReblog check if post is reblogged from other blog
+func (p *Post) isReblog() bool {
+	return p.RebloggedFromName != "" || p.RebloggedFromURL != ""
+}
+
+// newerThan check if post is newer than given post id and timestamp,
+// numeric post id is compared first since it always grow
+func (p *Post) newerThan(id string, timestamp int) bool {
+	pid, pErr := strconv.ParseInt(p.ID, 10, 64)
+	mid, mErr := strconv.ParseInt(id, 10, 64)
+	if pErr == nil && mErr == nil {
+		return pid > mid
+	}
+
+	return p.Timestamp > timestamp
+}
+
+// ConversationLine single line of chat post
+type ConversationLine struct {
+	Name  string `xml:"name,attr" json:"name"`
+	Label string `xml:"label,attr" json:"label"`
+	Text  string `xml:",chardata" json:"text"`
+}
+
+// VideoSource single detail on VideoPlayer
+type VideoSource struct {
+	ContentType string `xml:"content-type" json:"content_type,omitempty"`
+	Extension   string `xml:"extension" json:"extension,omitempty"`
+	Width       int    `xml:"width" json:"width,omitempty"`
+	Height      int    `xml:"height" json:"height,omitempty"`
+	Duration    int    `xml:"duration" json:"duration,omitempty"`
+}
+
+// VideoPlayer entity which contain video file url
+type VideoPlayer struct {
+	MaxWidth int    `xml:"max-width,attr" json:"max_width"`
+	Content  string `xml:",chardata" json:"content"`
+}
+
+// PhotoURL entity which contain photo file url
+type PhotoURL struct {
+	MaxWidth int    `xml:"max-width,attr" json:"max_width"`
+	FileURL  string `xml:",chardata" json:"url"`
+}
+
+// Photoset optional entity which can be exists on photo Post
+type Photoset struct {
+	Photo []Photo `xml:"photo" json:"photos,omitempty"`
+}
+
+// Photo entities which exists on Photoset, this contains photo file urls
+type Photo struct {
+	Offset   string     `xml:"offset,attr" json:"offset,omitempty"`
+	Caption  string     `xml:"caption,attr" json:"caption,omitempty"`
+	Width    int        `xml:"width,attr" json:"width,omitempty"`
+	Height   int        `xml:"height,attr" json:"height,omitempty"`
+	PhotoURL []PhotoURL `xml:"photo-url" json:"photo_urls"`
+}
@@ -0,0 +1,73 @@
+// Copyright (c) 2016 - Sarjono Mukti Aji <me@simukti.net>
+// Unless otherwise noted, this source code license is MIT-License
+
+package tumblr
+
+import (
+	"os"
+	"path/filepath"
+)
+
+const (
+	// VERIFYMISSING recorded file does not exist
+	VERIFYMISSING = "missing"
+
+	// VERIFYSIZE recorded file size differ from file on disk
+	VERIFYSIZE = "size"
+
+	// VERIFYHASH recorded sha256 differ from file content
+	VERIFYHASH = "hash"
+
+	// VERIFYERROR recorded file can not be read
+	VERIFYERROR = "error"
+)
+
+// VerifyResult single recorded file which is not intact
+type VerifyResult struct {
+	Entry   ManifestEntry
+	Path    string // full path of file
+	Problem string // VERIFYMISSING, VERIFYSIZE, VERIFYHASH or VERIFYERROR
+	Err     error  // only set on VERIFYERROR
+}
+
+// Verify check every completed file recorded in blog manifest inside dest against file on disk,
+// file content is hashed only if hash is true. It return number of checked files and every broken one.
+func Verify(dest, blog string, hash bool) (int, []VerifyResult, error) {
+	m, err := ReadManifest(filepath.Join(dest, blog, MANIFESTFILE))
+	if err != nil {
+		return 0, nil, err
+	}
+
+	checked := 0
+	broken := []VerifyResult{}
+	for _, e := range m.Entries() {
+		if e.Status != STATUSDONE {
+			continue
+		}
+		checked++
+
+		r := VerifyResult{Entry: e, Path: e.Path(dest)}
+		s, sErr := os.Stat(r.Path)
+		switch {
+		case os.IsNotExist(sErr):
+			r.Problem = VERIFYMISSING
+		case sErr != nil:
+			r.Problem, r.Err = VERIFYERROR, sErr
+		case s.Size() != e.Size:
+			r.Problem = VERIFYSIZE
+		case hash && e.Hash != "":
+			sum, _, hErr := fileHash(r.Path)
+			if hErr != nil {
+				r.Problem, r.Err = VERIFYERROR, hErr
+			} else if sum != e.Hash {
+				r.Problem = VERIFYHASH
+			}
+		}
+
+		if r.Problem != "" {
+			broken = append(broken, r)
+		}
+	}
+
+	return checked, broken, nil
+}
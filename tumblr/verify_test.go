@@ -0,0 +1,63 @@
+// Copyright (c) 2016 - Sarjono Mukti Aji <me@simukti.net>
+// Unless otherwise noted, this source code license is MIT-License
+
+package tumblr
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestVerify(t *testing.T) {
+	f := newFakeTumblr()
+	defer f.Close()
+	f.addPosts(PHOTO, photoPosts(1000, 1500000000, 4)...)
+	dest, clean := tempDest(t)
+	defer clean()
+
+	f.download(t, dest, Media(PHOTO))
+	checked, broken, err := Verify(dest, FAKEBLOG, true)
+	if err != nil || checked != 4 || len(broken) != 0 {
+		t.Fatalf("intact archive = %d checked, %+v, %v", checked, broken, err)
+	}
+
+	missing := photoFile(dest, 1000, 1500000000)
+	grown := photoFile(dest, 999, 1500000000-86400)
+	changed := photoFile(dest, 998, 1500000000-2*86400)
+	if err := os.Remove(missing); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(grown, []byte("grown"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	body, err := ioutil.ReadFile(changed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(changed, bytes.Repeat([]byte("y"), len(body)), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// same size content change is found only by hash
+	for hash, want := range map[bool]map[string]string{
+		false: {missing: VERIFYMISSING, grown: VERIFYSIZE},
+		true:  {missing: VERIFYMISSING, grown: VERIFYSIZE, changed: VERIFYHASH},
+	} {
+		checked, broken, err := Verify(dest, FAKEBLOG, hash)
+		if err != nil || checked != 4 || len(broken) != len(want) {
+			t.Errorf("hash %v = %d checked, %+v, %v", hash, checked, broken, err)
+			continue
+		}
+		for _, r := range broken {
+			if want[r.Path] != r.Problem || r.Err != nil || r.Entry.Status != STATUSDONE {
+				t.Errorf("hash %v result = %+v, want %s", hash, r, want[r.Path])
+			}
+		}
+	}
+
+	if _, _, err := Verify(dest, "unknown", false); err == nil {
+		t.Errorf("verify blog without manifest error = nil")
+	}
+}